@@ -0,0 +1,383 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// accountSchemaVersion is written into every account document. Bump it when
+// the layout of account changes and teach decodeAccount to upgrade the
+// older form.
+//
+// Version 3 accounts are listed in every accountIndexes entry; older ones are
+// indexed the next time they are saved. Version 4 adds private accounts,
+// whose documents carry no balance, version 5 tombstones, version 6
+// account classes, version 7 freezes and version 8 the balance time of
+// accruing accounts. Older accruals have none, as if the balance had not
+// changed since their interest was last posted. Version 9 records when a
+// freeze stopped interest.
+const accountSchemaVersion = 9
+
+// defaultCurrency is the currency of accounts that do not name their own.
+const defaultCurrency = "UNIT"
+
+// account is the ledger representation of an entity's asset holding.
+type account struct {
+	Version   int    `json:"version"`
+	ID        string `json:"id"`
+	Owner     string `json:"owner"`
+	Balance   Amount `json:"balance"`
+	Currency  string `json:"currency"`
+	CreatedTx string `json:"createdTx"`
+	UpdatedTx string `json:"updatedTx"`
+
+	// Private is set for accounts whose balance is kept in a private data
+	// collection. Balance then holds the private value once it has been
+	// read, and is never written to the public document.
+	Private *privateBalance `json:"private,omitempty"`
+	// Tombstone is set while the account is deleted.
+	Tombstone *tombstone `json:"tombstone,omitempty"`
+	// Accrual is set once the account is put in a class that earns
+	// interest or pays fees.
+	Accrual *accrual `json:"accrual,omitempty"`
+	// Frozen is set while compliance has frozen the account.
+	Frozen *accountFreeze `json:"frozen,omitempty"`
+
+	// indexed is set once the account's index entries are on the ledger.
+	indexed bool
+	// salt is the secret mixed into Private.Hash.
+	salt []byte
+	// loaded is the balance as read from the ledger, against which
+	// putAccount reports the change to the supply check.
+	loaded Amount
+}
+
+// tombstone marks a deleted account. The account stays on the ledger with
+// its balances frozen, so that an audit can tell a removed account from one
+// that never existed, and restore can bring the funds back.
+type tombstone struct {
+	// FinalBalance is left out for private accounts.
+	FinalBalance *Amount `json:"finalBalance,omitempty"`
+	DeletedBy    string  `json:"deletedBy"`
+	DeletedTx    string  `json:"deletedTx"`
+}
+
+// accountFields has the fields of account without its JSON methods.
+type accountFields account
+
+// accountDocument is the JSON form of an account. Balance is left out for
+// private accounts.
+type accountDocument struct {
+	*accountFields
+	Balance *Amount `json:"balance,omitempty"`
+}
+
+// MarshalJSON encodes the public view of acct.
+func (acct account) MarshalJSON() ([]byte, error) {
+	doc := accountDocument{accountFields: (*accountFields)(&acct)}
+	if acct.Private == nil {
+		doc.Balance = &acct.Balance
+	}
+	return json.Marshal(doc)
+}
+
+// accountIndex is a composite-key index over accounts. The last attribute of
+// each entry is the account ID, so a partial key lists every account under it.
+type accountIndex struct {
+	objectType string
+	attributes func(acct *account) []string
+}
+
+// accountIndexes are the namespaces that the prefix query can scan.
+var accountIndexes = []accountIndex{
+	{"currency~id", func(acct *account) []string { return []string{acct.Currency, acct.ID} }},
+	{"owner~id", func(acct *account) []string { return []string{acct.Owner, acct.ID} }},
+}
+
+// findAccountIndex returns the index stored under objectType, or nil.
+func findAccountIndex(objectType string) *accountIndex {
+	for i := range accountIndexes {
+		if accountIndexes[i].objectType == objectType {
+			return &accountIndexes[i]
+		}
+	}
+	return nil
+}
+
+// indexValue marks an index entry; the mock stub drops empty values.
+var indexValue = []byte{0x00}
+
+// accountSpec describes an account to open. An empty balance opens it at zero.
+type accountSpec struct {
+	ID      string `json:"id"`
+	Balance string `json:"balance,omitempty"`
+}
+
+// newAccount returns an account opened for owner by the current transaction.
+func newAccount(stub shim.ChaincodeStubInterface, id, owner string, balance Amount) *account {
+	return &account{
+		Version:   accountSchemaVersion,
+		ID:        id,
+		Owner:     owner,
+		Balance:   balance,
+		Currency:  defaultCurrency,
+		CreatedTx: stub.GetTxID(),
+	}
+}
+
+// encodeAccount serializes an account for the ledger.
+func encodeAccount(acct *account) ([]byte, error) {
+	return json.Marshal(acct)
+}
+
+// decodeAccount parses the state stored under key. Values written before the
+// JSON schema existed are bare ASCII integers; they are upgraded in memory and
+// rewritten in the current schema the next time the account is saved.
+func decodeAccount(key string, value []byte) (*account, error) {
+	if len(value) > 0 && value[0] != '{' {
+		balance, err := parseAmount(string(value))
+		if err != nil {
+			return nil, fmt.Errorf("Corrupt state for %s: %q is neither an account document nor a decimal", key, value)
+		}
+		return &account{
+			Version:  accountSchemaVersion,
+			ID:       key,
+			Balance:  balance,
+			Currency: defaultCurrency,
+			loaded:   balance,
+		}, nil
+	}
+
+	acct := &account{}
+	doc := accountDocument{accountFields: (*accountFields)(acct)}
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("Corrupt state for %s: %s", key, err)
+	}
+	if err := requireEOF(dec); err != nil {
+		return nil, fmt.Errorf("Corrupt state for %s: trailing data after account document", key)
+	}
+	if acct.Version < 1 || acct.Version > accountSchemaVersion {
+		return nil, fmt.Errorf("Corrupt state for %s: unsupported schema version %d", key, acct.Version)
+	}
+	if acct.ID != key {
+		return nil, fmt.Errorf("Corrupt state for %s: document belongs to %q", key, acct.ID)
+	}
+	if acct.Currency == "" {
+		return nil, fmt.Errorf("Corrupt state for %s: missing currency", key)
+	}
+	switch {
+	case acct.Private == nil && doc.Balance == nil:
+		return nil, fmt.Errorf("Corrupt state for %s: missing balance", key)
+	case acct.Private != nil && doc.Balance != nil:
+		return nil, fmt.Errorf("Corrupt state for %s: private account with a public balance", key)
+	case doc.Balance != nil:
+		acct.Balance = *doc.Balance
+		acct.loaded = acct.Balance
+	}
+	acct.indexed = acct.Version >= 3
+	return acct, nil
+}
+
+// getAccount reads and decodes the account stored under id, including the
+// balance of a private account. It returns nil without an error when no such
+// entity exists.
+func getAccount(stub shim.ChaincodeStubInterface, id string) (*account, error) {
+	acct, err := getPublicAccount(stub, id)
+	if err != nil || acct == nil || acct.Private == nil {
+		return acct, err
+	}
+	if err := loadPrivateBalance(stub, acct); err != nil {
+		return nil, err
+	}
+	return acct, nil
+}
+
+// getPublicAccount is getAccount without reading private balances, which
+// only peers of the collection hold.
+func getPublicAccount(stub shim.ChaincodeStubInterface, id string) (*account, error) {
+	value, err := stub.GetState(id)
+	if err != nil {
+		return nil, errors.New("Failed to get state for " + id)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return decodeAccount(id, value)
+}
+
+// loadAccount is getAccount for an entity that must exist.
+func loadAccount(stub shim.ChaincodeStubInterface, id string) (*account, error) {
+	acct, err := getAccount(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, newCodedError(codeEntityNotFound, "Entity %s not found", id)
+	}
+	return acct, nil
+}
+
+// loadPublicAccount is getPublicAccount for an entity that must exist.
+func loadPublicAccount(stub shim.ChaincodeStubInterface, id string) (*account, error) {
+	acct, err := getPublicAccount(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, newCodedError(codeEntityNotFound, "Entity %s not found", id)
+	}
+	return acct, nil
+}
+
+// loadLiveAccount is loadAccount for an entity that must not be deleted.
+func loadLiveAccount(stub shim.ChaincodeStubInterface, id string) (*account, error) {
+	acct, err := loadAccount(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireLive(acct); err != nil {
+		return nil, err
+	}
+	return acct, nil
+}
+
+// requireLive refuses to move funds in or out of a deleted account.
+func requireLive(acct *account) error {
+	if acct.Tombstone != nil {
+		return newCodedError(codeAccountDeleted, "Account %s was deleted in transaction %s", acct.ID, acct.Tombstone.DeletedTx)
+	}
+	return nil
+}
+
+// putAccount stamps the account with the current transaction and writes it
+// back to the ledger in the current schema.
+func putAccount(stub shim.ChaincodeStubInterface, acct *account) error {
+	if acct.Accrual != nil && acct.Balance.Cmp(acct.loaded) != 0 {
+		if err := noteBalanceChange(stub, acct); err != nil {
+			return err
+		}
+	}
+	if acct.Private != nil {
+		if err := putPrivateBalance(stub, acct); err != nil {
+			return err
+		}
+	}
+	return putPublicAccount(stub, acct)
+}
+
+// putPublicAccount is putAccount for an account read with getPublicAccount,
+// whose balance must not change. It leaves the balance of a private account
+// in its collection, so that peers outside the collection can write it.
+func putPublicAccount(stub shim.ChaincodeStubInterface, acct *account) error {
+	acct.Version = accountSchemaVersion
+	acct.UpdatedTx = stub.GetTxID()
+	value, err := encodeAccount(acct)
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(acct.ID, value); err != nil {
+		return err
+	}
+	trackBalance(stub, accountHolder(acct), acct.Currency, acct.loaded, acct.Balance)
+	acct.loaded = acct.Balance
+
+	if !acct.indexed {
+		for _, index := range accountIndexes {
+			key, err := stub.CreateCompositeKey(index.objectType, index.attributes(acct))
+			if err != nil {
+				return err
+			}
+			if err := stub.PutState(key, indexValue); err != nil {
+				return err
+			}
+		}
+		acct.indexed = true
+	}
+	return nil
+}
+
+// validateAccountID rejects names that cannot be used as plain ledger keys.
+// Keys starting with a NUL byte belong to the composite key namespace.
+func validateAccountID(id string) error {
+	if id == "" {
+		return newCodedError(codeInvalidArgument, "Account name must not be empty")
+	}
+	if !utf8.ValidString(id) || id[0] == 0 {
+		return newCodedError(codeInvalidArgument, "Invalid account name %q", id)
+	}
+	return nil
+}
+
+// openAccount validates spec and returns the account it describes, owned by
+// owner. It fails if the name is already taken, or if a non-admin asks for an
+// opening balance, which would issue new funds.
+func openAccount(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, owner string, spec accountSpec) (*account, error) {
+	if err := validateAccountID(spec.ID); err != nil {
+		return nil, err
+	}
+	balance := Amount{}.quantize(cfg.Scale)
+	if spec.Balance != "" {
+		var err error
+		balance, err = parseHolding(spec.Balance, cfg.Scale)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if balance.Sign() > 0 && !cfg.isAdmin(owner) {
+		return nil, newCodedError(codeUnauthorized, "Opening %s with a balance issues funds and may only be done by an admin", spec.ID)
+	}
+
+	existing, err := getAccount(stub, spec.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, newCodedError(codeAccountExists, "Account %s already exists", spec.ID)
+	}
+	return newAccount(stub, spec.ID, owner, balance), nil
+}
+
+// decodeStrict unmarshals a JSON argument, rejecting unknown fields and
+// trailing data.
+func decodeStrict(text string, v interface{}) error {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	return requireEOF(dec)
+}
+
+// requireEOF fails unless dec has nothing but white space left. Unlike
+// dec.More it also catches a stray closing brace or bracket.
+func requireEOF(dec *json.Decoder) error {
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return errors.New("unexpected data after JSON document")
+	}
+	return nil
+}
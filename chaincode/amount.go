@@ -0,0 +1,231 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Amount is an arbitrary-precision decimal held as an integer number of
+// 10^-scale units. Arithmetic is exact; the only rounding happens in quantize,
+// which uses round-half-to-even on integers so every endorser gets the same
+// result. Amounts are immutable: every operation returns a new value.
+type Amount struct {
+	units *big.Int
+	scale int
+}
+
+var bigTen = big.NewInt(10)
+
+// parseAmount parses a plain decimal such as "12", "-3.5" or "0.125". Signs
+// other than a leading minus, exponents and surrounding spaces are rejected.
+// The result keeps every fractional digit that was supplied.
+func parseAmount(s string) (Amount, error) {
+	digits := strings.TrimPrefix(s, "-")
+	intPart, fracPart := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		intPart, fracPart = digits[:i], digits[i+1:]
+		if fracPart == "" {
+			return Amount{}, fmt.Errorf("malformed decimal %q", s)
+		}
+	}
+	if intPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return Amount{}, fmt.Errorf("malformed decimal %q", s)
+	}
+
+	units, ok := new(big.Int).SetString(intPart+fracPart, 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("malformed decimal %q", s)
+	}
+	if len(digits) != len(s) {
+		units.Neg(units)
+	}
+	return Amount{units: units, scale: len(fracPart)}, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (a Amount) int() *big.Int {
+	if a.units == nil {
+		return new(big.Int)
+	}
+	return a.units
+}
+
+// rescale returns a with exactly scale fractional digits, which must not be
+// fewer than it already has.
+func (a Amount) rescale(scale int) *big.Int {
+	units := new(big.Int).Set(a.int())
+	if scale > a.scale {
+		factor := new(big.Int).Exp(bigTen, big.NewInt(int64(scale-a.scale)), nil)
+		units.Mul(units, factor)
+	}
+	return units
+}
+
+// quantize rounds a to scale fractional digits, halves going to the even
+// neighbour.
+func (a Amount) quantize(scale int) Amount {
+	if a.scale <= scale {
+		return Amount{units: a.rescale(scale), scale: scale}
+	}
+
+	divisor := new(big.Int).Exp(bigTen, big.NewInt(int64(a.scale-scale)), nil)
+	return Amount{units: roundQuo(a.int(), divisor), scale: scale}
+}
+
+// mulFrac returns a * b * num / den rounded like quantize to scale
+// fractional digits. The product is exact before the one rounding, so it is
+// the same on every endorser. den must be positive.
+func (a Amount) mulFrac(b Amount, num, den *big.Int, scale int) Amount {
+	n := new(big.Int).Mul(a.int(), b.int())
+	n.Mul(n, num)
+	n.Mul(n, new(big.Int).Exp(bigTen, big.NewInt(int64(scale)), nil))
+	d := new(big.Int).Exp(bigTen, big.NewInt(int64(a.scale+b.scale)), nil)
+	d.Mul(d, den)
+	return Amount{units: roundQuo(n, d), scale: scale}
+}
+
+// roundQuo returns n / d rounded to the nearest integer, halves going to the
+// even neighbour. d must be positive.
+func roundQuo(n, d *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	twice := new(big.Int).Abs(r)
+	twice.Lsh(twice, 1)
+	if c := twice.Cmp(d); c > 0 || (c == 0 && q.Bit(0) == 1) {
+		if n.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// Add returns a + b.
+func (a Amount) Add(b Amount) Amount {
+	scale := maxScale(a, b)
+	return Amount{units: new(big.Int).Add(a.rescale(scale), b.rescale(scale)), scale: scale}
+}
+
+// Sub returns a - b.
+func (a Amount) Sub(b Amount) Amount {
+	scale := maxScale(a, b)
+	return Amount{units: new(big.Int).Sub(a.rescale(scale), b.rescale(scale)), scale: scale}
+}
+
+// Cmp compares a and b and returns -1, 0 or +1.
+func (a Amount) Cmp(b Amount) int {
+	scale := maxScale(a, b)
+	return a.rescale(scale).Cmp(b.rescale(scale))
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of a.
+func (a Amount) Sign() int {
+	return a.int().Sign()
+}
+
+func maxScale(a, b Amount) int {
+	if a.scale > b.scale {
+		return a.scale
+	}
+	return b.scale
+}
+
+// String formats a as a plain decimal with all of its fractional digits.
+func (a Amount) String() string {
+	digits := new(big.Int).Abs(a.int()).String()
+	if a.scale > 0 {
+		if len(digits) <= a.scale {
+			digits = strings.Repeat("0", a.scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-a.scale] + "." + digits[len(digits)-a.scale:]
+	}
+	if a.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// MarshalJSON encodes a as a JSON string so no precision is lost to clients
+// that parse numbers as floats.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts a decimal string or, for documents written before
+// amounts were strings, a bare JSON integer.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if strings.HasPrefix(text, "\"") {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	}
+	parsed, err := parseAmount(text)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// parseHolding parses an opening balance and quantizes it to scale. Zero is
+// allowed, negative holdings are not.
+func parseHolding(s string, scale int) (Amount, error) {
+	a, err := parseAmount(s)
+	if err != nil {
+		return Amount{}, newCodedError(codeInvalidAmount, "Expecting decimal value for asset holding, got %q", s)
+	}
+	if a.Sign() < 0 {
+		return Amount{}, newCodedError(codeNegativeAmount, "Asset holding %s must not be negative", s)
+	}
+	return a.quantize(scale), nil
+}
+
+// parseTransferAmount parses the value of a transfer at scale. Amounts with
+// more fractional digits than scale are rejected rather than rounded, since
+// rounding would move a different amount than the caller asked for.
+// Negative and zero amounts are rejected with their own codes so clients can
+// tell them apart.
+func parseTransferAmount(s string, scale int) (Amount, error) {
+	a, err := parseAmount(s)
+	if err != nil {
+		return Amount{}, newCodedError(codeInvalidAmount, "Invalid transaction amount %q, expecting a decimal value", s)
+	}
+	if a.Sign() < 0 {
+		return Amount{}, newCodedError(codeNegativeAmount, "Transaction amount %s must not be negative", s)
+	}
+	q := a.quantize(scale)
+	if q.Cmp(a) != 0 {
+		return Amount{}, newCodedError(codeInvalidArgument, "Transaction amount %s has more than %d decimal places", s, scale)
+	}
+	if q.Sign() == 0 {
+		return Amount{}, newCodedError(codeZeroAmount, "Transaction amount %s is zero", s)
+	}
+	return q, nil
+}
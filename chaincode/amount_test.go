@@ -0,0 +1,113 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	for _, s := range []string{"0", "12", "-3.5", "0.125", "123456789012345678901234567890.01"} {
+		a, err := parseAmount(s)
+		if err != nil {
+			t.Errorf("parseAmount(%q): %s", s, err)
+			continue
+		}
+		if a.String() != s {
+			t.Errorf("parseAmount(%q) formats as %s", s, a)
+		}
+	}
+	for _, s := range []string{"", "-", ".5", "1.", "+1", "1e3", " 1", "1,5", "--1", "0x10", "1.2.3"} {
+		if _, err := parseAmount(s); err == nil {
+			t.Errorf("parseAmount(%q) succeeded", s)
+		}
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale int
+		out   string
+	}{
+		{"1.005", 2, "1.00"},
+		{"1.015", 2, "1.02"},
+		{"1.0051", 2, "1.01"},
+		{"-1.005", 2, "-1.00"},
+		{"-1.015", 2, "-1.02"},
+		{"2.5", 0, "2"},
+		{"3.5", 0, "4"},
+		{"0.001", 2, "0.00"},
+		{"7", 3, "7.000"},
+	}
+	for _, test := range tests {
+		a, err := parseAmount(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.quantize(test.scale).String(); got != test.out {
+			t.Errorf("%s quantized to %d places is %s, expected %s", test.in, test.scale, got, test.out)
+		}
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	a, _ := parseAmount("100")
+	b, _ := parseAmount("0.25")
+	if got := a.Sub(b).String(); got != "99.75" {
+		t.Errorf("100 - 0.25 = %s", got)
+	}
+	if got := a.Add(b).String(); got != "100.25" {
+		t.Errorf("100 + 0.25 = %s", got)
+	}
+	if a.Cmp(b) != 1 || b.Cmp(a) != -1 || a.Cmp(a.quantize(4)) != 0 {
+		t.Error("Cmp disagrees across scales")
+	}
+	if (Amount{}).Sign() != 0 || (Amount{}).String() != "0" {
+		t.Error("zero Amount is not zero")
+	}
+	// 100 * 0.25 * 1/3 = 8.333...
+	if got := a.mulFrac(b, big.NewInt(1), big.NewInt(3), 2).String(); got != "8.33" {
+		t.Errorf("100 * 0.25 / 3 = %s", got)
+	}
+	// 0.25 * 0.25 * 2/5 = 0.025 rounds to the even neighbour.
+	if got := b.mulFrac(b, big.NewInt(2), big.NewInt(5), 2).String(); got != "0.02" {
+		t.Errorf("0.25 * 0.25 * 2 / 5 = %s", got)
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	var v struct {
+		A Amount `json:"a"`
+		B Amount `json:"b"`
+	}
+	if err := json.Unmarshal([]byte(`{"a":"12.50","b":7}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"a":"12.50","b":"7"}` {
+		t.Errorf("round trip produced %s", out)
+	}
+	if err := json.Unmarshal([]byte(`{"a":1.5e3}`), &v); err == nil {
+		t.Error("exponent notation was accepted")
+	}
+}
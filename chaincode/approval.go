@@ -0,0 +1,516 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Composite key namespaces of pending transfers, keyed by the transaction
+// that made them, and of the index listing them under each approver.
+const (
+	approvalObjectType      = "approval"
+	approvalIndexObjectType = "approval~approver~id"
+)
+
+// defaultApprovalWindow is how long a pending transfer waits for a checker
+// when the approvals option sets no window.
+const defaultApprovalWindow = 24 * time.Hour
+
+// Pending transfer states. Only a pending transfer holds funds; every other
+// state is final.
+const (
+	approvalPending  = "PENDING"
+	approvalApproved = "APPROVED"
+	approvalRejected = "REJECTED"
+	approvalExpired  = "EXPIRED"
+)
+
+// approvalConfig makes transfers above Threshold wait for a second identity
+// to approve them. Approvers defaults to the admins. Threshold is an amount
+// of the account currency; transfers of registered assets, whose units it
+// does not measure, never wait for approval.
+type approvalConfig struct {
+	Threshold *Amount  `json:"threshold"`
+	Approvers []string `json:"approvers,omitempty"`
+	// Window is how long an approver has to act, as a Go duration.
+	Window string `json:"window,omitempty"`
+}
+
+// pendingTransfer is a transfer above the approval threshold. The amount is
+// taken from the payer when the maker submits it and held until a checker
+// approves or rejects it, or it expires.
+type pendingTransfer struct {
+	ID     string `json:"id"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount Amount `json:"amount"`
+	Asset  string `json:"asset"`
+	// Maker submitted the transfer. Approvers are the identities that may
+	// check it, which never include the maker.
+	Maker     string   `json:"maker"`
+	Approvers []string `json:"approvers"`
+	Deadline  string   `json:"deadline"`
+	State     string   `json:"state"`
+	Checker   string   `json:"checker,omitempty"`
+	ClosedTx  string   `json:"closedTx,omitempty"`
+}
+
+// pendingTransferEvent is emitted whenever a pending transfer changes state.
+type pendingTransferEvent struct {
+	eventHeader
+	Transfer *pendingTransfer `json:"transfer"`
+}
+
+// approvalThresholdEvent is emitted by setApprovalThreshold.
+type approvalThresholdEvent struct {
+	eventHeader
+	Threshold Amount `json:"threshold"`
+	UpdatedBy string `json:"updatedBy"`
+}
+
+// validate checks the approvals option of Init.
+func (c *approvalConfig) validate() error {
+	if c.Threshold == nil || c.Threshold.Sign() < 0 {
+		return errors.New("approvals.threshold must be a non-negative amount")
+	}
+	for _, approver := range c.Approvers {
+		if approver == "" {
+			return errors.New("approvals.approvers must not list an empty identity")
+		}
+	}
+	if c.Window != "" {
+		d, err := time.ParseDuration(c.Window)
+		if err != nil || d <= 0 {
+			return errors.New("approvals.window must be a positive duration")
+		}
+	}
+	return nil
+}
+
+// needsApproval reports whether a transfer of amount of symbol must wait for
+// a checker.
+func (cfg *chaincodeConfig) needsApproval(symbol string, amount Amount) bool {
+	return cfg.Approvals != nil && symbol == defaultCurrency && amount.Cmp(*cfg.Approvals.Threshold) > 0
+}
+
+// checkers lists the identities that may approve a transfer made by maker.
+func (cfg *chaincodeConfig) checkers(maker string) []string {
+	approvers := cfg.Admins
+	if len(cfg.Approvals.Approvers) > 0 {
+		approvers = cfg.Approvals.Approvers
+	}
+	var checkers []string
+	for _, approver := range approvers {
+		if approver != maker && !contains(checkers, approver) {
+			checkers = append(checkers, approver)
+		}
+	}
+	return checkers
+}
+
+// approvalWindow returns how long a pending transfer waits for a checker.
+func approvalWindow(cfg *chaincodeConfig) (time.Duration, error) {
+	if cfg.Approvals.Window == "" {
+		return defaultApprovalWindow, nil
+	}
+	window, err := time.ParseDuration(cfg.Approvals.Window)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("Corrupt chaincode configuration: invalid approvals.window %q", cfg.Approvals.Window)
+	}
+	return window, nil
+}
+
+func approvalKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	return stub.CreateCompositeKey(approvalObjectType, []string{id})
+}
+
+// getPendingTransfer reads the pending transfer stored under id, or nil if
+// there is none.
+func getPendingTransfer(stub shim.ChaincodeStubInterface, id string) (*pendingTransfer, error) {
+	key, err := approvalKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state for pending transfer " + id)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	p := &pendingTransfer{}
+	if err := json.Unmarshal(value, p); err != nil {
+		return nil, fmt.Errorf("Corrupt state for pending transfer %s: %s", id, err)
+	}
+	return p, nil
+}
+
+func putPendingTransfer(stub shim.ChaincodeStubInterface, p *pendingTransfer) error {
+	key, err := approvalKey(stub, p.ID)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, value)
+}
+
+// loadPendingTransfer reads a transfer that must exist and still wait for a
+// checker.
+func loadPendingTransfer(stub shim.ChaincodeStubInterface, id string) (*pendingTransfer, error) {
+	p, err := getPendingTransfer(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, newArgumentError(codeApprovalNotFound, "id", "Pending transfer %s not found", id)
+	}
+	if p.State != approvalPending {
+		return nil, newCodedError(codeInvalidState, "Transfer %s is already %s", id, p.State)
+	}
+	return p, nil
+}
+
+// indexApprovers adds p to, or with remove takes it off, the list of every
+// approver that may check it.
+func indexApprovers(stub shim.ChaincodeStubInterface, p *pendingTransfer, remove bool) error {
+	for _, approver := range p.Approvers {
+		key, err := stub.CreateCompositeKey(approvalIndexObjectType, []string{approver, p.ID})
+		if err != nil {
+			return err
+		}
+		if remove {
+			err = stub.DelState(key)
+		} else {
+			err = stub.PutState(key, indexValue)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// holdTransfer takes amount of a from Aacct, whose balance it leaves at
+// Aval, and holds it for B until a checker acts. It returns the pending
+// transfer, whose ID the checker needs.
+func holdTransfer(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, Aacct *account, B string, a *asset, Aval, amount Amount) ([]byte, error) {
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	checkers := cfg.checkers(caller)
+	if len(checkers) == 0 {
+		return nil, newCodedError(codeUnauthorized, "Transfers above %s need an approver other than the caller", *cfg.Approvals.Threshold)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	window, err := approvalWindow(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pendingTransfer{
+		ID:        stub.GetTxID(),
+		From:      Aacct.ID,
+		To:        B,
+		Amount:    amount,
+		Asset:     a.Symbol,
+		Maker:     caller,
+		Approvers: checkers,
+		Deadline:  now.Add(window).UTC().Format(time.RFC3339),
+		State:     approvalPending,
+	}
+	fmt.Printf("Transfer %s of %s %s from %s to %s awaits approval\n", p.ID, amount, a.Symbol, p.From, B)
+
+	err = setBalance(stub, Aacct, a, Aval)
+	if err != nil {
+		return nil, err
+	}
+	err = putPendingTransfer(stub, p)
+	if err != nil {
+		return nil, err
+	}
+	err = indexApprovers(stub, p, false)
+	if err != nil {
+		return nil, err
+	}
+	trackBalance(stub, holder{kind: holderApproval, id: p.ID}, a.Symbol, Amount{}, amount)
+
+	err = setEvent(stub, eventTransferPending, &pendingTransferEvent{eventHeader: newEventHeader(stub), Transfer: p})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(p)
+}
+
+// requireChecker fails with an authorization error unless the caller may
+// check p, and returns the caller.
+func requireChecker(stub shim.ChaincodeStubInterface, p *pendingTransfer) (string, error) {
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return "", err
+	}
+	if caller == p.Maker {
+		return "", newCodedError(codeUnauthorized, "Transfer %s must be checked by an identity other than its maker", p.ID)
+	}
+	if !contains(p.Approvers, caller) {
+		return "", newCodedError(codeUnauthorized, "Only an approver may check transfer %s", p.ID)
+	}
+	return caller, nil
+}
+
+// Pays a pending transfer out to its payee. Only an approver other than the
+// maker may approve it, and only before its deadline. The payee's KYC status
+// is checked again, since it may have lapsed while the transfer waited.
+func (t *SimpleChaincode) approveTransfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	p, err := loadPendingTransfer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	caller, err := requireChecker(stub, p)
+	if err != nil {
+		return nil, err
+	}
+	expired, err := p.expired(stub)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, newCodedError(codeExpired, "Transfer %s expired at %s", p.ID, p.Deadline)
+	}
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireKYC(stub, cfg, p.From, p.To)
+	if err != nil {
+		return nil, err
+	}
+	p.Checker = caller
+
+	return settleTransfer(stub, cfg, p, p.To, approvalApproved, eventTransferApproved)
+}
+
+// Returns a pending transfer to its payer. Only an approver other than the
+// maker may reject it.
+func (t *SimpleChaincode) rejectTransfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	p, err := loadPendingTransfer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	caller, err := requireChecker(stub, p)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	p.Checker = caller
+
+	return settleTransfer(stub, cfg, p, p.From, approvalRejected, eventTransferRejected)
+}
+
+// Returns a pending transfer that has passed its deadline to the payer.
+// Anyone may call it, so funds never stay held because no approver acted.
+func (t *SimpleChaincode) expireTransfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	p, err := loadPendingTransfer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	expired, err := p.expired(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !expired {
+		return nil, newCodedError(codeNotExpired, "Transfer %s does not expire until %s", p.ID, p.Deadline)
+	}
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return settleTransfer(stub, cfg, p, p.From, approvalExpired, eventTransferExpired)
+}
+
+// Sets the amount of the account currency above which transfers need
+// approval, turning approvals on if Init did not. Only an admin may set it.
+func (t *SimpleChaincode) setApprovalThreshold(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	threshold, err := parseAmount(args[0])
+	if err != nil {
+		return nil, newArgumentError(codeInvalidAmount, "threshold", "Invalid approval threshold %q, expecting a decimal value", args[0])
+	}
+	if threshold.Sign() < 0 {
+		return nil, newArgumentError(codeNegativeAmount, "threshold", "The approval threshold cannot be negative")
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireAdmin(stub, cfg, "Setting the approval threshold")
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Approvals == nil {
+		cfg.Approvals = &approvalConfig{}
+	}
+	cfg.Approvals.Threshold = &threshold
+	fmt.Printf("Transfers above %s now need approval\n", threshold)
+	err = putConfig(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventApprovalThreshold, &approvalThresholdEvent{
+		eventHeader: newEventHeader(stub),
+		Threshold:   threshold,
+		UpdatedBy:   caller,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Returns a pending transfer record.
+func (t *SimpleChaincode) pendingTransferQuery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	p, err := getPendingTransfer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, newArgumentError(codeApprovalNotFound, "id", "Pending transfer %s not found", args[0])
+	}
+	return json.Marshal(p)
+}
+
+// Returns the transfers waiting for an approver, the caller unless one is
+// named, oldest first. Transfers past their deadline are listed until they
+// are expired.
+func (t *SimpleChaincode) pendingApprovals(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var approver string
+	if len(args) > 0 {
+		approver = args[0]
+	} else {
+		caller, err := callerIdentity(stub)
+		if err != nil {
+			return nil, err
+		}
+		approver = caller
+	}
+
+	iter, err := stub.GetStateByPartialCompositeKey(approvalIndexObjectType, []string{approver})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get pending approvals: %s", err)
+	}
+	defer iter.Close()
+
+	pending := []*pendingTransfer{}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get pending approvals: %s", err)
+		}
+		_, keyParts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) != 2 {
+			return nil, fmt.Errorf("Corrupt approval index entry %q", kv.Key)
+		}
+		p, err := getPendingTransfer(stub, keyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, fmt.Errorf("Approval index refers to missing transfer %s", keyParts[1])
+		}
+		pending = append(pending, p)
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].Deadline < pending[j].Deadline
+	})
+	return json.Marshal(pending)
+}
+
+// expired reports whether the transaction is at or past the deadline of p.
+func (p *pendingTransfer) expired(stub shim.ChaincodeStubInterface) (bool, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return false, err
+	}
+	deadline, err := time.Parse(time.RFC3339, p.Deadline)
+	if err != nil {
+		return false, fmt.Errorf("Corrupt state for pending transfer %s: %s", p.ID, err)
+	}
+	return !now.Before(deadline), nil
+}
+
+// settleTransfer credits the held funds to id and closes the pending
+// transfer in the given final state.
+func settleTransfer(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, p *pendingTransfer, id, state, eventName string) ([]byte, error) {
+	err := releaseFunds(stub, cfg, holder{kind: holderApproval, id: p.ID}, id, p.Asset, p.Amount, "")
+	if err != nil {
+		return nil, err
+	}
+	if state != approvalApproved {
+		// The payer's daily cap no longer counts funds it got back.
+		err = dropOutflow(stub, p.From, p.Asset, p.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.State = state
+	p.ClosedTx = stub.GetTxID()
+	fmt.Printf("Transfer %s %s\n", p.ID, state)
+
+	err = putPendingTransfer(stub, p)
+	if err != nil {
+		return nil, err
+	}
+	err = indexApprovers(stub, p, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventName, &pendingTransferEvent{eventHeader: newEventHeader(stub), Transfer: p})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
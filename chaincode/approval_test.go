@@ -0,0 +1,170 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newApprovalFixture holds transfers above 50 for an hour, with bob as the
+// approver.
+func newApprovalFixture(t *testing.T) (*fixture, *identity) {
+	bob := newIdentity(t, "Org1MSP", "bob")
+	options := fmt.Sprintf(`{"approvals":{"threshold":"50","approvers":[%q],"window":"1h"}}`, newTestStub().id(t, bob))
+	return newFixture(t, "A", "100", "B", "200", options), bob
+}
+
+// hold submits a transfer that must wait for approval and returns it.
+func (f *fixture) hold(t *testing.T, args ...string) *pendingTransfer {
+	t.Helper()
+	res := f.invoke(f.admin, append([]string{"transfer"}, args...)...)
+	checkOK(t, res)
+	f.checkEvent(t, eventTransferPending)
+	p := &pendingTransfer{}
+	if err := json.Unmarshal(res.Payload, p); err != nil {
+		t.Fatal(err)
+	}
+	if p.State != approvalPending {
+		t.Fatalf("expected a pending transfer, got %s", res.Payload)
+	}
+	return p
+}
+
+// pendingFor lists the transfers waiting for approver.
+func (f *fixture) pendingFor(t *testing.T, approver string) []*pendingTransfer {
+	t.Helper()
+	res := f.invoke(f.alice, "pendingApprovals", approver)
+	checkOK(t, res)
+	var pending []*pendingTransfer
+	if err := json.Unmarshal(res.Payload, &pending); err != nil {
+		t.Fatal(err)
+	}
+	return pending
+}
+
+func TestApproveTransfer(t *testing.T) {
+	f, bob := newApprovalFixture(t)
+	p := f.hold(t, "A", "B", "60")
+	if p.Deadline != "2020-01-01T13:00:00Z" || len(p.Approvers) != 1 || p.Approvers[0] != f.id(t, bob) {
+		t.Fatalf("unexpected pending transfer %+v", p)
+	}
+	f.checkBalance(t, "A", "40")
+	f.checkBalance(t, "B", "200")
+	f.checkSupply(t, defaultCurrency, "300")
+
+	if pending := f.pendingFor(t, f.id(t, bob)); len(pending) != 1 || pending[0].ID != p.ID {
+		t.Fatalf("expected %s to wait for bob, got %+v", p.ID, pending)
+	}
+	if pending := f.pendingFor(t, f.id(t, f.admin)); len(pending) != 0 {
+		t.Fatalf("the maker is listed as an approver: %+v", pending)
+	}
+
+	checkFailed(t, f.invoke(f.admin, "approveTransfer", p.ID), shim.ERRORTHRESHOLD, codeUnauthorized)
+	checkFailed(t, f.invoke(f.alice, "approveTransfer", p.ID), shim.ERRORTHRESHOLD, codeUnauthorized)
+	checkOK(t, f.invoke(bob, "approveTransfer", p.ID))
+	f.checkEvent(t, eventTransferApproved)
+	f.checkBalance(t, "B", "260")
+	f.checkSupply(t, defaultCurrency, "300")
+	f.audit(t, "A")
+	f.audit(t, "B")
+
+	if pending := f.pendingFor(t, f.id(t, bob)); len(pending) != 0 {
+		t.Fatalf("approved transfer still listed: %+v", pending)
+	}
+	checkFailed(t, f.invoke(bob, "rejectTransfer", p.ID), shim.ERRORTHRESHOLD, codeInvalidState)
+
+	// Transfers up to the threshold are made at once.
+	checkOK(t, f.invoke(f.admin, "transfer", "B", "A", "50"))
+	f.checkBalance(t, "A", "90")
+}
+
+func TestRejectAndExpireTransfer(t *testing.T) {
+	f, bob := newApprovalFixture(t)
+	rejected := f.hold(t, "A", "B", "60")
+	checkOK(t, f.invoke(bob, "rejectTransfer", rejected.ID))
+	f.checkEvent(t, eventTransferRejected)
+	f.checkBalance(t, "A", "100")
+
+	expired := f.hold(t, "A", "B", "70")
+	checkFailed(t, f.invoke(f.alice, "expireTransfer", expired.ID), shim.ERRORTHRESHOLD, codeNotExpired)
+	f.now = f.now.Add(time.Hour)
+	checkFailed(t, f.invoke(bob, "approveTransfer", expired.ID), shim.ERRORTHRESHOLD, codeExpired)
+	checkOK(t, f.invoke(f.alice, "expireTransfer", expired.ID))
+	f.checkBalance(t, "A", "100")
+	f.checkBalance(t, "B", "200")
+
+	res := f.invoke(f.alice, "pendingTransfer", expired.ID)
+	checkOK(t, res)
+	p := &pendingTransfer{}
+	if err := json.Unmarshal(res.Payload, p); err != nil {
+		t.Fatal(err)
+	}
+	if p.State != approvalExpired || p.Checker != "" {
+		t.Fatalf("unexpected expired transfer %s", res.Payload)
+	}
+	checkFailed(t, f.invoke(f.alice, "pendingTransfer", "tx0"), shim.ERRORTHRESHOLD, codeApprovalNotFound)
+}
+
+func TestReturnedTransferLeavesDailyCap(t *testing.T) {
+	f, bob := newApprovalFixture(t)
+	checkOK(t, f.invoke(f.admin, "setPolicy", "A", `{"limits":{"UNIT":{"dailyCap":"100"}}}`))
+	rejected := f.hold(t, "A", "B", "60")
+	checkOK(t, f.invoke(bob, "rejectTransfer", rejected.ID))
+	expired := f.hold(t, "A", "B", "60")
+	f.now = f.now.Add(time.Hour)
+	checkOK(t, f.invoke(f.alice, "expireTransfer", expired.ID))
+
+	// Only the payments that went out count towards the cap.
+	approved := f.hold(t, "A", "B", "60")
+	checkOK(t, f.invoke(bob, "approveTransfer", approved.ID))
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "40"))
+	checkOK(t, f.invoke(f.admin, "transfer", "B", "A", "1"))
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1"), shim.ERRORTHRESHOLD, codeDailyLimitExceeded)
+}
+
+func TestApprovalThreshold(t *testing.T) {
+	f := newFixture(t)
+	checkFailed(t, f.invoke(f.alice, "setApprovalThreshold", "50"), shim.ERRORTHRESHOLD, codeUnauthorized)
+	checkFailed(t, f.invoke(f.admin, "setApprovalThreshold", "-1"), shim.ERRORTHRESHOLD, codeNegativeAmount)
+	checkOK(t, f.invoke(f.admin, "setApprovalThreshold", "50"))
+	f.checkEvent(t, eventApprovalThreshold)
+
+	// The admins approve by default, and the only admin cannot check itself.
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "60"), shim.ERRORTHRESHOLD, "need an approver other than the caller")
+	checkFailed(t, f.invoke(f.admin, "batchTransfer", "A", `[{"to":"B","amount":"60"}]`), shim.ERRORTHRESHOLD, codeApprovalRequired)
+	checkFailed(t, f.invoke(f.admin, "batchTransfer", "A", `[{"to":"B","amount":"30"},{"to":"B","amount":"30"}]`), shim.ERRORTHRESHOLD, codeApprovalRequired)
+	checkOK(t, f.invoke(f.alice, "create", "C"))
+	checkOK(t, f.invoke(f.admin, "batchTransfer", "A", `[{"to":"B","amount":"30"},{"to":"C","amount":"30"}]`))
+
+	// Locks cannot wait for a checker, so they are refused outright.
+	expiry := f.now.Add(time.Hour).Format(time.RFC3339)
+	checkFailed(t, f.invoke(f.admin, "escrowOpen", "e1", "A", "B", f.id(t, f.alice), "60", expiry), shim.ERRORTHRESHOLD, codeApprovalRequired)
+	checkFailed(t, f.invoke(f.admin, "htlcLock", "h1", "A", "B", "60", hashOf(t, testPreimage), expiry), shim.ERRORTHRESHOLD, codeApprovalRequired)
+	checkOK(t, f.invoke(f.admin, "htlcLock", "h1", "A", "B", "40", hashOf(t, testPreimage), expiry))
+}
+
+func TestApprovalOptions(t *testing.T) {
+	s := newTestStub()
+	admin := newIdentity(t, "Org1MSP", "admin")
+	checkFailed(t, s.init(admin, "A", "100", "B", "200", `{"approvals":{}}`), shim.ERRORTHRESHOLD, "threshold must be a non-negative amount")
+	checkFailed(t, s.init(admin, "A", "100", "B", "200", `{"approvals":{"threshold":"1","window":"0s"}}`), shim.ERRORTHRESHOLD, "window must be a positive duration")
+}
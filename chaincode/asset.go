@@ -0,0 +1,248 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Composite key namespaces of the asset registry and of the holdings of
+// assets other than an account's own currency.
+const (
+	assetObjectType   = "asset"
+	holdingObjectType = "balance~account~asset"
+)
+
+// assetSymbol is the form of a registered asset symbol.
+var assetSymbol = regexp.MustCompile(`^[A-Z][A-Z0-9]{0,11}$`)
+
+// asset is a registered token type. The account currency, defaultCurrency,
+// is built in: it has the deployment scale as its decimals and no issuer.
+type asset struct {
+	Symbol    string `json:"symbol"`
+	Decimals  int    `json:"decimals"`
+	Issuer    string `json:"issuer"`
+	CreatedTx string `json:"createdTx,omitempty"`
+}
+
+// holding is the balance of one account in an asset other than its currency.
+// The currency balance stays in the account document, so deployments that
+// never register an asset keep their state unchanged.
+type holding struct {
+	Account   string `json:"account"`
+	Asset     string `json:"asset"`
+	Balance   Amount `json:"balance"`
+	UpdatedTx string `json:"updatedTx"`
+}
+
+// assetRegisteredEvent is emitted when an asset is registered.
+type assetRegisteredEvent struct {
+	eventHeader
+	Asset *asset `json:"asset"`
+}
+
+// getAsset returns the asset registered under symbol. Unknown symbols are a
+// caller error.
+func getAsset(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, symbol string) (*asset, error) {
+	if symbol == defaultCurrency {
+		return &asset{Symbol: defaultCurrency, Decimals: cfg.Scale}, nil
+	}
+	key, err := stub.CreateCompositeKey(assetObjectType, []string{symbol})
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state for asset " + symbol)
+	}
+	if value == nil {
+		return nil, newCodedError(codeUnknownAsset, "Asset %s is not registered", symbol)
+	}
+	a := &asset{}
+	if err := json.Unmarshal(value, a); err != nil {
+		return nil, fmt.Errorf("Corrupt state for asset %s: %s", symbol, err)
+	}
+	return a, nil
+}
+
+func putAsset(stub shim.ChaincodeStubInterface, a *asset) error {
+	key, err := stub.CreateCompositeKey(assetObjectType, []string{a.Symbol})
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, value)
+}
+
+func holdingKey(stub shim.ChaincodeStubInterface, id, symbol string) (string, error) {
+	return stub.CreateCompositeKey(holdingObjectType, []string{id, symbol})
+}
+
+// getHolding reads the holding of acct in a, or nil if it has none.
+func getHolding(stub shim.ChaincodeStubInterface, acct *account, a *asset) (*holding, error) {
+	key, err := holdingKey(stub, acct.ID, a.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s balance of %s", a.Symbol, acct.ID)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	h := &holding{}
+	if err := json.Unmarshal(value, h); err != nil {
+		return nil, fmt.Errorf("Corrupt %s balance of %s: %s", a.Symbol, acct.ID, err)
+	}
+	return h, nil
+}
+
+// balanceOf returns what acct holds of a; zero if it never held any.
+func balanceOf(stub shim.ChaincodeStubInterface, acct *account, a *asset) (Amount, error) {
+	if a.Symbol == acct.Currency {
+		return acct.Balance, nil
+	}
+	if acct.Private != nil {
+		return Amount{}, newCodedError(codeInvalidArgument, "Private account %s holds only %s", acct.ID, acct.Currency)
+	}
+	h, err := getHolding(stub, acct, a)
+	if err != nil {
+		return Amount{}, err
+	}
+	if h == nil {
+		return Amount{}.quantize(a.Decimals), nil
+	}
+	return h.Balance, nil
+}
+
+// setBalance writes balance as what acct holds of a. The currency balance is
+// saved with the account itself. Like accounts, a holding may be written only
+// once per transaction.
+func setBalance(stub shim.ChaincodeStubInterface, acct *account, a *asset, balance Amount) error {
+	if a.Symbol == acct.Currency {
+		acct.Balance = balance
+		return putAccount(stub, acct)
+	}
+	old, err := balanceOf(stub, acct, a)
+	if err != nil {
+		return err
+	}
+	key, err := holdingKey(stub, acct.ID, a.Symbol)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(&holding{Account: acct.ID, Asset: a.Symbol, Balance: balance, UpdatedTx: stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return err
+	}
+	trackBalance(stub, accountHolder(acct), a.Symbol, old, balance)
+	return nil
+}
+
+// listHoldings returns every holding of acct.
+func listHoldings(stub shim.ChaincodeStubInterface, acct *account) ([]heldAsset, error) {
+	iter, err := stub.GetStateByPartialCompositeKey(holdingObjectType, []string{acct.ID})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list holdings of %s: %s", acct.ID, err)
+	}
+	defer iter.Close()
+
+	var held []heldAsset
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list holdings of %s: %s", acct.ID, err)
+		}
+		h := &holding{}
+		if err := json.Unmarshal(kv.Value, h); err != nil {
+			return nil, fmt.Errorf("Corrupt holding %q: %s", kv.Key, err)
+		}
+		held = append(held, heldAsset{Asset: h.Asset, Balance: h.Balance})
+	}
+	return held, nil
+}
+
+// Registers an asset: symbol, decimals, issuer. The issuer is an identity in
+// the form returned by whoami. Only an admin may register assets, and a
+// symbol can be registered once.
+func (t *SimpleChaincode) registerAsset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	symbol, issuer := args[0], args[2]
+	if !assetSymbol.MatchString(symbol) {
+		return nil, newCodedError(codeInvalidArgument, "Asset symbol %q must be 1 to 12 upper-case letters and digits", symbol)
+	}
+	decimals, err := strconv.Atoi(args[1])
+	if err != nil || decimals < 0 || decimals > maxAmountScale {
+		return nil, newCodedError(codeInvalidArgument, "Asset decimals must be between 0 and %d, got %q", maxAmountScale, args[1])
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireAdmin(stub, cfg, "Registering an asset")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getAsset(stub, cfg, symbol)
+	if err == nil {
+		return nil, newCodedError(codeAssetExists, "Asset %s is already registered", symbol)
+	}
+	if coded, ok := err.(*codedError); !ok || coded.Code != codeUnknownAsset {
+		return nil, err
+	}
+
+	a := &asset{Symbol: symbol, Decimals: decimals, Issuer: issuer, CreatedTx: stub.GetTxID()}
+	fmt.Printf("Registered asset %s with %d decimals\n", symbol, decimals)
+	err = putAsset(stub, a)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventAssetRegistered, &assetRegisteredEvent{eventHeader: newEventHeader(stub), Asset: a})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Returns the registration of an asset.
+func (t *SimpleChaincode) assetQuery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	a, err := getAsset(stub, cfg, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(a)
+}
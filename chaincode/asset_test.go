@@ -0,0 +1,131 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newAssetFixture registers GOLD with two decimals and gives A 10.00 of it.
+func newAssetFixture(t *testing.T) *fixture {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "registerAsset", "GOLD", "2", f.id(t, f.admin)))
+	checkOK(t, f.invoke(f.admin, "mint", "A", "10", "GOLD"))
+	return f
+}
+
+// checkHolding asserts what id holds of symbol.
+func (f *fixture) checkHolding(t *testing.T, id, symbol, want string) {
+	t.Helper()
+	res := f.invoke(f.alice, "query", id, symbol)
+	checkOK(t, res)
+	var doc balanceDocument
+	if err := json.Unmarshal(res.Payload, &doc); err != nil {
+		t.Fatalf("query %s %s: %s", id, symbol, err)
+	}
+	if doc.Asset != symbol || doc.Amount.String() != want {
+		t.Fatalf("%s holds %s, expected %s %s", id, res.Payload, want, symbol)
+	}
+}
+
+func TestRegisterAsset(t *testing.T) {
+	f := newAssetFixture(t)
+	res := f.invoke(f.alice, "asset", "GOLD")
+	checkOK(t, res)
+	a := &asset{}
+	if err := json.Unmarshal(res.Payload, a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Decimals != 2 || a.Issuer != f.id(t, f.admin) {
+		t.Fatalf("unexpected registration %+v", a)
+	}
+
+	tests := []struct {
+		name    string
+		caller  *identity
+		args    []string
+		message string
+	}{
+		{name: "registered twice", caller: f.admin, args: []string{"GOLD", "2", "x"}, message: codeAssetExists},
+		{name: "account currency", caller: f.admin, args: []string{defaultCurrency, "0", "x"}, message: codeAssetExists},
+		{name: "non-admin", caller: f.alice, args: []string{"SILVER", "2", "x"}, message: codeUnauthorized},
+		{name: "lower-case symbol", caller: f.admin, args: []string{"silver", "2", "x"}, message: codeInvalidArgument},
+		{name: "too many decimals", caller: f.admin, args: []string{"SILVER", "31", "x"}, message: codeInvalidArgument},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkFailed(t, f.invoke(test.caller, append([]string{"registerAsset"}, test.args...)...), shim.ERRORTHRESHOLD, test.message)
+		})
+	}
+	checkFailed(t, f.invoke(f.alice, "asset", "SILVER"), shim.ERRORTHRESHOLD, codeUnknownAsset)
+}
+
+func TestAssetTransferSkipsApproval(t *testing.T) {
+	f := newAssetFixture(t)
+	checkOK(t, f.invoke(f.admin, "setApprovalThreshold", "1"))
+
+	// The threshold is in the account currency and does not apply to GOLD.
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "5", "GOLD"))
+	f.checkEvent(t, eventTransfer)
+	f.checkHolding(t, "B", "GOLD", "5.00")
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "5"), shim.ERRORTHRESHOLD, "need an approver other than the caller")
+}
+
+func TestAssetTransfer(t *testing.T) {
+	f := newAssetFixture(t)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "1.25", "GOLD"))
+	f.checkEvent(t, eventTransfer)
+	event := &transferEvent{}
+	if err := json.Unmarshal(f.lastEvent.Payload, event); err != nil || event.Asset != "GOLD" {
+		t.Fatalf("expected a GOLD transfer event, got %s", f.lastEvent.Payload)
+	}
+	f.checkHolding(t, "A", "GOLD", "8.75")
+	f.checkHolding(t, "B", "GOLD", "1.25")
+	f.checkHolding(t, "A", defaultCurrency, "100")
+	f.checkBalance(t, "A", "100")
+	f.checkBalance(t, "B", "200")
+
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "5", defaultCurrency))
+	f.checkBalance(t, "A", "95")
+
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "8.76", "GOLD"), shim.ERRORTHRESHOLD, codeInsufficientFunds)
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1", "SILVER"), shim.ERRORTHRESHOLD, codeUnknownAsset)
+	checkFailed(t, f.invoke(f.alice, "transfer", "A", "B", "1", "GOLD"), shim.ERRORTHRESHOLD, codeUnauthorized)
+	checkFailed(t, f.invoke(f.alice, "query", "A", "SILVER"), shim.ERRORTHRESHOLD, codeUnknownAsset)
+	f.checkHolding(t, "A", "GOLD", "8.75")
+}
+
+func TestDeleteFreezesHoldings(t *testing.T) {
+	f := newAssetFixture(t)
+	checkOK(t, f.invoke(f.admin, "delete", "A"))
+	event := &accountDeletedEvent{}
+	if err := json.Unmarshal(f.lastEvent.Payload, event); err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Holdings) != 1 || event.Holdings[0].Asset != "GOLD" || event.Holdings[0].Balance.String() != "10.00" {
+		t.Fatalf("expected the GOLD holding in the event, got %s", f.lastEvent.Payload)
+	}
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1", "GOLD"), shim.ERRORTHRESHOLD, codeAccountDeleted)
+	checkFailed(t, f.invoke(f.admin, "transfer", "B", "A", "1", "GOLD"), shim.ERRORTHRESHOLD, codeAccountDeleted)
+
+	checkOK(t, f.invoke(f.admin, "restore", "A"))
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "1", "GOLD"))
+	f.checkHolding(t, "A", "GOLD", "9.00")
+}
@@ -0,0 +1,177 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// maxBatchPayments bounds the size of a single batchTransfer.
+const maxBatchPayments = 1000
+
+// payment is one credit of a batchTransfer.
+type payment struct {
+	To     string `json:"to"`
+	Amount string `json:"amount"`
+}
+
+// batchPayment is one credit as reported in a BatchTransfer event.
+type batchPayment struct {
+	To     string `json:"to"`
+	Amount Amount `json:"amount"`
+}
+
+// batchTransferEvent is emitted when one account pays several others.
+type batchTransferEvent struct {
+	eventHeader
+	From     string         `json:"from"`
+	Payments []batchPayment `json:"payments"`
+	Total    Amount         `json:"total"`
+}
+
+// Pays a JSON list of {"to","amount"} entries out of one account. The whole
+// batch is checked against the payer's balance before anything is written,
+// so either every payment is made or none is. Like transfer, it may be tagged
+// with a request ID. Payments above the approval threshold are refused.
+func (t *SimpleChaincode) batchTransfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 2 {
+		return idempotent(stub, "batchTransfer", args[2], args[:2], func() ([]byte, error) {
+			return t.batchTransfer(stub, args[:2])
+		})
+	}
+
+	A := args[0]
+
+	var payments []payment
+	if err := decodeStrict(args[1], &payments); err != nil {
+		return nil, newArgumentError(codeInvalidArgument, "payments", "Invalid payment list: %s", err)
+	}
+	if len(payments) == 0 {
+		return nil, newArgumentError(codeInvalidArgument, "payments", "Invalid payment list: no payments given")
+	}
+	if len(payments) > maxBatchPayments {
+		return nil, newArgumentError(codeInvalidArgument, "payments", "Invalid payment list: at most %d payments are allowed", maxBatchPayments)
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	Aacct, err := loadLiveAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	err = requireUnfrozen(Aacct)
+	if err != nil {
+		return nil, err
+	}
+	err = requireOwner(stub, cfg, Aacct)
+	if err != nil {
+		return nil, err
+	}
+
+	// The ledger does not show a transaction its own writes, so a payee
+	// listed twice is loaded once and credited with both amounts.
+	payees := make(map[string]*account)
+	var order []*account
+	// The approval threshold applies to all a payee gets, so splitting a
+	// payment across entries does not avoid it.
+	owed := make(map[string]Amount)
+	event := &batchTransferEvent{eventHeader: newEventHeader(stub), From: A}
+	debits := make([]debit, 0, len(payments))
+	total := Amount{}.quantize(cfg.Scale)
+	for _, p := range payments {
+		if p.To == A {
+			return nil, newCodedError(codeSelfTransfer, "Cannot transfer from %s to itself", A)
+		}
+		X, err := parseTransferAmount(p.Amount, cfg.Scale)
+		if err != nil {
+			return nil, err
+		}
+		owed[p.To] = owed[p.To].Add(X)
+		if cfg.needsApproval(Aacct.Currency, owed[p.To]) {
+			return nil, newCodedError(codeApprovalRequired, "Payments of %s to %s need approval, make them with transfer", owed[p.To], p.To)
+		}
+
+		Bacct := payees[p.To]
+		if Bacct == nil {
+			Bacct, err = loadLiveAccount(stub, p.To)
+			if err != nil {
+				return nil, err
+			}
+			err = requireUnfrozen(Bacct)
+			if err != nil {
+				return nil, err
+			}
+			payees[p.To] = Bacct
+			order = append(order, Bacct)
+		}
+		Bacct.Balance = Bacct.Balance.Add(X)
+		total = total.Add(X)
+		debits = append(debits, debit{to: p.To, amount: X})
+		event.Payments = append(event.Payments, batchPayment{To: p.To, Amount: X})
+	}
+
+	available, err := availableBalance(stub, Aacct, Aacct.Currency, Aacct.Balance)
+	if err != nil {
+		return nil, err
+	}
+	if available.Cmp(total) < 0 {
+		return nil, newCodedError(codeInsufficientFunds, "%s has %s available, cannot pay out %s", A, available, total)
+	}
+	a, err := getAsset(stub, cfg, Aacct.Currency)
+	if err != nil {
+		return nil, err
+	}
+	parties := []string{A}
+	for _, Bacct := range order {
+		parties = append(parties, Bacct.ID)
+	}
+	err = requireKYC(stub, cfg, parties...)
+	if err != nil {
+		return nil, err
+	}
+	err = enforcePolicy(stub, Aacct, a, debits...)
+	if err != nil {
+		return nil, err
+	}
+	Aacct.Balance = Aacct.Balance.Sub(total)
+	event.Total = total
+	fmt.Printf("Aval = %s after paying %s to %d accounts\n", Aacct.Balance, total, len(order))
+
+	// Write the state back to the ledger
+	err = putAccount(stub, Aacct)
+	if err != nil {
+		return nil, err
+	}
+	for _, Bacct := range order {
+		err = putAccount(stub, Bacct)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = setEvent(stub, eventBatchTransfer, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestBatchTransfer(t *testing.T) {
+	tests := []struct {
+		name     string
+		asAlice  bool
+		payments string
+		status   int32
+		message  string
+		balances map[string]string
+	}{
+		{name: "pays every payee", payments: `[{"to":"B","amount":"10"},{"to":"C","amount":"5"}]`, balances: map[string]string{"A": "85", "B": "210", "C": "5"}},
+		{name: "payee listed twice", payments: `[{"to":"B","amount":"10"},{"to":"B","amount":"15"}]`, balances: map[string]string{"A": "75", "B": "225"}},
+		{name: "whole balance", payments: `[{"to":"B","amount":"60"},{"to":"C","amount":"40"}]`, balances: map[string]string{"A": "0", "B": "260", "C": "40"}},
+		{name: "total exceeds balance", payments: `[{"to":"B","amount":"60"},{"to":"C","amount":"41"}]`, status: shim.ERRORTHRESHOLD, message: codeInsufficientFunds},
+		{name: "negative payment", payments: `[{"to":"B","amount":"10"},{"to":"C","amount":"-5"}]`, status: shim.ERRORTHRESHOLD, message: codeNegativeAmount},
+		{name: "payment to payer", payments: `[{"to":"A","amount":"1"}]`, status: shim.ERRORTHRESHOLD, message: codeSelfTransfer},
+		{name: "unknown payee", payments: `[{"to":"B","amount":"1"},{"to":"Z","amount":"1"}]`, status: shim.ERRORTHRESHOLD, message: codeEntityNotFound},
+		{name: "empty batch", payments: `[]`, status: shim.ERRORTHRESHOLD, message: "no payments given"},
+		{name: "unknown field", payments: `[{"to":"B","amount":"1","memo":"x"}]`, status: shim.ERRORTHRESHOLD, message: "Invalid payment list"},
+		{name: "non-owner", asAlice: true, payments: `[{"to":"B","amount":"1"}]`, status: shim.ERRORTHRESHOLD, message: codeUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			checkOK(t, f.invoke(f.admin, "create", "C"))
+			caller := f.admin
+			if test.asAlice {
+				caller = f.alice
+			}
+
+			res := f.invoke(caller, "batchTransfer", "A", test.payments)
+			if test.status != 0 {
+				checkFailed(t, res, test.status, test.message)
+				f.checkBalance(t, "A", "100")
+				f.checkBalance(t, "B", "200")
+				f.checkBalance(t, "C", "0")
+				return
+			}
+			checkOK(t, res)
+			f.checkEvent(t, eventBatchTransfer)
+			for id, want := range test.balances {
+				f.checkBalance(t, id, want)
+			}
+		})
+	}
+}
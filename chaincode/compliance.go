@@ -0,0 +1,393 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// holdObjectType is the composite key namespace of holds, keyed by account
+// and hold ID so that the holds of an account can be listed together.
+const holdObjectType = "hold"
+
+// maxReasonLength bounds the reason codes compliance actions carry.
+const maxReasonLength = 64
+
+// accountFreeze marks an account that may neither send nor receive funds.
+type accountFreeze struct {
+	Reason   string `json:"reason"`
+	FrozenBy string `json:"frozenBy"`
+	FrozenTx string `json:"frozenTx"`
+	// FrozenAt is when the account stopped accruing interest. Freezes
+	// from before schema version 9 have none and stopped nothing.
+	FrozenAt string `json:"frozenAt,omitempty"`
+}
+
+// hold sets part of an account's balance in one asset aside. The held amount
+// cannot be paid out until compliance releases it. A hold may exceed the
+// balance, so that funds arriving later are held too.
+type hold struct {
+	Account  string `json:"account"`
+	ID       string `json:"id"`
+	Asset    string `json:"asset"`
+	Amount   Amount `json:"amount"`
+	Reason   string `json:"reason"`
+	PlacedBy string `json:"placedBy"`
+	PlacedTx string `json:"placedTx"`
+}
+
+// complianceEvent is emitted by freeze, unfreeze, hold and releaseHold. Hold
+// is set for the last two.
+type complianceEvent struct {
+	eventHeader
+	Account string `json:"account"`
+	Reason  string `json:"reason"`
+	By      string `json:"by"`
+	Hold    *hold  `json:"hold,omitempty"`
+}
+
+// validateReason checks the reason code of a compliance action, such as
+// SANCTIONS or COURT_ORDER: upper case letters, digits and underscores.
+func validateReason(reason string) error {
+	if reason == "" || len(reason) > maxReasonLength {
+		return newArgumentError(codeInvalidArgument, "reason", "Reason codes are 1 to %d characters long", maxReasonLength)
+	}
+	for _, c := range reason {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			return newArgumentError(codeInvalidArgument, "reason", "Reason code %q may only hold upper case letters, digits and underscores", reason)
+		}
+	}
+	return nil
+}
+
+// requireUnfrozen refuses to move funds in or out of a frozen account.
+func requireUnfrozen(acct *account) error {
+	if acct.Frozen != nil {
+		return newCodedError(codeAccountFrozen, "Account %s was frozen in transaction %s (%s)", acct.ID, acct.Frozen.FrozenTx, acct.Frozen.Reason)
+	}
+	return nil
+}
+
+func holdKey(stub shim.ChaincodeStubInterface, id, holdID string) (string, error) {
+	return stub.CreateCompositeKey(holdObjectType, []string{id, holdID})
+}
+
+// getHold reads hold holdID on account id, or nil if there is none.
+func getHold(stub shim.ChaincodeStubInterface, id, holdID string) (*hold, error) {
+	key, err := holdKey(stub, id, holdID)
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state for hold " + holdID)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	h := &hold{}
+	if err := json.Unmarshal(value, h); err != nil {
+		return nil, fmt.Errorf("Corrupt state for hold %s: %s", holdID, err)
+	}
+	return h, nil
+}
+
+// listHolds reads every hold on account id.
+func listHolds(stub shim.ChaincodeStubInterface, id string) ([]*hold, error) {
+	iter, err := stub.GetStateByPartialCompositeKey(holdObjectType, []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get holds of %s: %s", id, err)
+	}
+	defer iter.Close()
+
+	holds := []*hold{}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get holds of %s: %s", id, err)
+		}
+		h := &hold{}
+		if err := json.Unmarshal(kv.Value, h); err != nil {
+			return nil, fmt.Errorf("Corrupt hold %q: %s", kv.Key, err)
+		}
+		holds = append(holds, h)
+	}
+	return holds, nil
+}
+
+// availableBalance is what acct may pay out of balance, its balance in
+// symbol, once its holds in symbol are set aside. It is negative when the
+// holds exceed the balance.
+func availableBalance(stub shim.ChaincodeStubInterface, acct *account, symbol string, balance Amount) (Amount, error) {
+	holds, err := listHolds(stub, acct.ID)
+	if err != nil {
+		return Amount{}, err
+	}
+	for _, h := range holds {
+		if h.Asset == symbol {
+			balance = balance.Sub(h.Amount)
+		}
+	}
+	return balance, nil
+}
+
+// Freezes an account with a reason code. A frozen account can neither send
+// nor receive funds. That includes refunds of funds it put in an escrow, an
+// HTLC or a pending transfer: they stay where they are until compliance
+// unfreezes the account. Only compliance may freeze it.
+func (t *SimpleChaincode) freeze(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	A, reason := args[0], args[1]
+	if err := validateReason(reason); err != nil {
+		return nil, err
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireCompliance(stub, cfg, "Freezing an account")
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	acct, err := loadPublicAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	if acct.Frozen != nil {
+		return nil, newCodedError(codeInvalidState, "Account %s is already frozen", A)
+	}
+	acct.Frozen = &accountFreeze{Reason: reason, FrozenBy: caller, FrozenTx: stub.GetTxID()}
+	fmt.Printf("Froze %s: %s\n", A, reason)
+	err = pauseAccrual(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putPublicAccount(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventAccountFrozen, &complianceEvent{eventHeader: newEventHeader(stub), Account: A, Reason: reason, By: caller})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Lifts the freeze of an account, with the reason code for lifting it. Only
+// compliance may unfreeze it.
+func (t *SimpleChaincode) unfreeze(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	A, reason := args[0], args[1]
+	if err := validateReason(reason); err != nil {
+		return nil, err
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireCompliance(stub, cfg, "Unfreezing an account")
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	acct, err := loadPublicAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	if acct.Frozen == nil {
+		return nil, newCodedError(codeInvalidState, "Account %s is not frozen", A)
+	}
+	fmt.Printf("Unfroze %s, frozen in %s: %s\n", A, acct.Frozen.FrozenTx, reason)
+	err = resumeAccrual(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+	acct.Frozen = nil
+
+	err = putPublicAccount(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventAccountUnfrozen, &complianceEvent{eventHeader: newEventHeader(stub), Account: A, Reason: reason, By: caller})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Holds an amount of an account's balance: entity, holdId, amount, reason
+// [, asset]. The hold is in the account currency unless an asset is named.
+// Only compliance may place it.
+func (t *SimpleChaincode) hold(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	A, holdID, reason := args[0], args[1], args[3]
+	if err := validateReason(reason); err != nil {
+		return nil, err
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireCompliance(stub, cfg, "Placing a hold")
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	acct, err := getPublicAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, newArgumentError(codeEntityNotFound, "entity", "Entity %s not found", A)
+	}
+	symbol := acct.Currency
+	if len(args) > 4 {
+		symbol = args[4]
+	}
+	a, err := getAsset(stub, cfg, symbol)
+	if err != nil {
+		return nil, withArgument(err, "asset")
+	}
+	X, err := parseTransferAmount(args[2], a.Decimals)
+	if err != nil {
+		return nil, withArgument(err, "amount")
+	}
+	existing, err := getHold(stub, A, holdID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, newArgumentError(codeHoldExists, "holdId", "Account %s already has hold %s", A, holdID)
+	}
+
+	h := &hold{
+		Account:  A,
+		ID:       holdID,
+		Asset:    a.Symbol,
+		Amount:   X,
+		Reason:   reason,
+		PlacedBy: caller,
+		PlacedTx: stub.GetTxID(),
+	}
+	fmt.Printf("Hold %s sets %s %s of %s aside: %s\n", holdID, X, a.Symbol, A, reason)
+
+	key, err := holdKey(stub, A, holdID)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventHoldPlaced, &complianceEvent{eventHeader: newEventHeader(stub), Account: A, Reason: reason, By: caller, Hold: h})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Releases a hold, with the reason code for releasing it: entity, holdId,
+// reason. Only compliance may release it.
+func (t *SimpleChaincode) releaseHold(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	A, holdID, reason := args[0], args[1], args[2]
+	if err := validateReason(reason); err != nil {
+		return nil, err
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireCompliance(stub, cfg, "Releasing a hold")
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := getHold(stub, A, holdID)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, newArgumentError(codeHoldNotFound, "holdId", "Account %s has no hold %s", A, holdID)
+	}
+	fmt.Printf("Released hold %s of %s %s on %s: %s\n", holdID, h.Amount, h.Asset, A, reason)
+
+	key, err := holdKey(stub, A, holdID)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventHoldReleased, &complianceEvent{eventHeader: newEventHeader(stub), Account: A, Reason: reason, By: caller, Hold: h})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Returns the holds on an account.
+func (t *SimpleChaincode) holdsQuery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	A := args[0]
+	acct, err := getPublicAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, newArgumentError(codeEntityNotFound, "entity", "Entity %s not found", A)
+	}
+	holds, err := listHolds(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(holds)
+}
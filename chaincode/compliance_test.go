@@ -0,0 +1,134 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// checkCompliance asserts that the last invocation emitted name with reason.
+func (f *fixture) checkCompliance(t *testing.T, name, reason string) *complianceEvent {
+	t.Helper()
+	f.checkEvent(t, name)
+	event := &complianceEvent{}
+	if err := json.Unmarshal(f.lastEvent.Payload, event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Reason != reason {
+		t.Fatalf("expected reason %s, got %s", reason, f.lastEvent.Payload)
+	}
+	return event
+}
+
+func TestFreeze(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "escrowOpen", "e1", "A", "B", f.id(t, f.admin), "5", f.now.Add(time.Hour).Format(time.RFC3339)))
+	checkOK(t, f.invoke(f.admin, "freeze", "A", "SANCTIONS"))
+	f.checkCompliance(t, eventAccountFrozen, "SANCTIONS")
+
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1"), shim.ERRORTHRESHOLD, codeAccountFrozen)
+	checkFailed(t, f.invoke(f.admin, "transfer", "B", "A", "1"), shim.ERRORTHRESHOLD, codeAccountFrozen)
+	checkFailed(t, f.invoke(f.admin, "batchTransfer", "B", `[{"to":"A","amount":"1"}]`), shim.ERRORTHRESHOLD, codeAccountFrozen)
+	checkFailed(t, f.invoke(f.admin, "mint", "A", "1"), shim.ERRORTHRESHOLD, codeAccountFrozen)
+	checkFailed(t, f.invoke(f.admin, "freeze", "A", "SANCTIONS"), shim.ERRORTHRESHOLD, codeInvalidState)
+
+	// Funds the account had escrowed stay in escrow until it is unfrozen.
+	checkFailed(t, f.invoke(f.admin, "escrowRefund", "e1"), shim.ERRORTHRESHOLD, codeAccountFrozen)
+	f.checkBalance(t, "A", "95")
+
+	checkOK(t, f.invoke(f.admin, "unfreeze", "A", "CLEARED"))
+	f.checkCompliance(t, eventAccountUnfrozen, "CLEARED")
+	checkOK(t, f.invoke(f.admin, "escrowRefund", "e1"))
+	f.checkBalance(t, "A", "100")
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "1"))
+	checkFailed(t, f.invoke(f.admin, "unfreeze", "A", "CLEARED"), shim.ERRORTHRESHOLD, codeInvalidState)
+}
+
+func TestFreezePrivateAccount(t *testing.T) {
+	f := newPrivateFixture(t)
+	hash := f.publicDocument(t, "P")["private"]
+
+	// Compliance need not be in the collection to freeze the account.
+	record := f.PvtState[testCollection]["P"]
+	delete(f.PvtState[testCollection], "P")
+	checkOK(t, f.invoke(f.admin, "freeze", "P", "SANCTIONS"))
+	checkOK(t, f.invoke(f.admin, "unfreeze", "P", "CLEARED"))
+	if f.PvtState[testCollection]["P"] != nil {
+		t.Fatal("freezing wrote the private balance")
+	}
+	if doc := f.publicDocument(t, "P"); !reflect.DeepEqual(doc["private"], hash) {
+		t.Fatalf("freezing changed the private section: %v", doc["private"])
+	}
+
+	f.PvtState[testCollection]["P"] = record
+	f.checkBalance(t, "P", "50")
+}
+
+func TestHold(t *testing.T) {
+	f := newAssetFixture(t)
+	checkOK(t, f.invoke(f.admin, "hold", "A", "h1", "70", "COURT_ORDER"))
+	event := f.checkCompliance(t, eventHoldPlaced, "COURT_ORDER")
+	if event.Hold == nil || event.Hold.Amount.String() != "70" || event.Hold.Asset != defaultCurrency {
+		t.Fatalf("unexpected hold event %s", f.lastEvent.Payload)
+	}
+	checkOK(t, f.invoke(f.admin, "hold", "A", "h2", "5", "COURT_ORDER", "GOLD"))
+	checkFailed(t, f.invoke(f.admin, "hold", "A", "h1", "1", "COURT_ORDER"), shim.ERRORTHRESHOLD, codeHoldExists)
+
+	// The balance is unchanged, but only what is not held can be paid out.
+	f.checkBalance(t, "A", "100")
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "31"), shim.ERRORTHRESHOLD, codeInsufficientFunds)
+	checkFailed(t, f.invoke(f.admin, "batchTransfer", "A", `[{"to":"B","amount":"20"},{"to":"B","amount":"11"}]`), shim.ERRORTHRESHOLD, codeInsufficientFunds)
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "5.01", "GOLD"), shim.ERRORTHRESHOLD, codeInsufficientFunds)
+	checkFailed(t, f.invoke(f.admin, "burn", "A", "5.01", "GOLD"), shim.ERRORTHRESHOLD, codeInsufficientFunds)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "30"))
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "5", "GOLD"))
+
+	res := f.invoke(f.alice, "holds", "A")
+	checkOK(t, res)
+	var holds []*hold
+	if err := json.Unmarshal(res.Payload, &holds); err != nil {
+		t.Fatal(err)
+	}
+	if len(holds) != 2 {
+		t.Fatalf("expected 2 holds, got %s", res.Payload)
+	}
+
+	checkOK(t, f.invoke(f.admin, "releaseHold", "A", "h1", "RELEASED_BY_COURT"))
+	f.checkCompliance(t, eventHoldReleased, "RELEASED_BY_COURT")
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "70"))
+	checkFailed(t, f.invoke(f.admin, "releaseHold", "A", "h1", "RELEASED_BY_COURT"), shim.ERRORTHRESHOLD, codeHoldNotFound)
+}
+
+func TestComplianceRole(t *testing.T) {
+	carol := newIdentity(t, "Org1MSP", "carol")
+	f := newFixture(t, "A", "100", "B", "200", fmt.Sprintf(`{"compliance":[%q]}`, newTestStub().id(t, carol)))
+
+	checkFailed(t, f.invoke(f.admin, "freeze", "A", "SANCTIONS"), shim.ERRORTHRESHOLD, codeUnauthorized)
+	checkFailed(t, f.invoke(f.admin, "hold", "A", "h1", "1", "SANCTIONS"), shim.ERRORTHRESHOLD, codeUnauthorized)
+	checkOK(t, f.invoke(carol, "freeze", "A", "SANCTIONS"))
+	checkFailed(t, f.invoke(f.admin, "unfreeze", "A", "CLEARED"), shim.ERRORTHRESHOLD, codeUnauthorized)
+
+	checkFailed(t, f.invoke(carol, "hold", "A", "h1", "1", "sanctions"), shim.ERRORTHRESHOLD, "upper case letters")
+	checkFailed(t, f.invoke(carol, "hold", "A", "h1", "0", "SANCTIONS"), shim.ERRORTHRESHOLD, codeZeroAmount)
+	checkFailed(t, f.invoke(carol, "hold", "Z", "h1", "1", "SANCTIONS"), shim.ERRORTHRESHOLD, codeEntityNotFound)
+}
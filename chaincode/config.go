@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Account keys are plain entity names, so every other record lives under a
+// composite key where it cannot collide with them.
+const configObjectType = "config"
+
+// maxAmountScale bounds the number of fractional digits a deployment may use.
+const maxAmountScale = 30
+
+// chaincodeConfig holds the deployment settings chosen at Init.
+type chaincodeConfig struct {
+	// Scale is the number of fractional digits amounts are rounded to.
+	// Deployments that predate it keep whole-number amounts.
+	Scale int `json:"scale"`
+	// Admins may delete accounts and issue funds. Init defaults it to the
+	// identity that instantiated the chaincode.
+	Admins []string `json:"admins,omitempty"`
+	// Compliance may freeze accounts and hold funds. It defaults to the
+	// admins.
+	Compliance []string `json:"compliance,omitempty"`
+	// KYC, when set, names the registry chaincode that must approve both
+	// sides of every payment.
+	KYC *kycConfig `json:"kyc,omitempty"`
+	// Requests sets for how many blocks client request IDs are remembered.
+	// Nil keeps the defaults.
+	Requests *requestConfig `json:"requests,omitempty"`
+	// Approvals, when set, holds large transfers until a second identity
+	// approves them. Admins may change its threshold later.
+	Approvals *approvalConfig `json:"approvals,omitempty"`
+}
+
+// initOptions is the JSON options argument of Init: the deployment settings,
+// and whether Init should migrate a deployment that already has some.
+type initOptions struct {
+	*chaincodeConfig
+	// Migrate lets Init replace the settings already on the ledger and
+	// reset accounts that already exist. Without it, re-running Init, as a
+	// chaincode upgrade does, keeps both.
+	Migrate bool `json:"migrate,omitempty"`
+}
+
+// defaultConfig returns the settings of a deployment that supplied none.
+func defaultConfig() *chaincodeConfig {
+	return &chaincodeConfig{Scale: 0}
+}
+
+// parseConfig decodes the optional JSON options argument of Init.
+func parseConfig(options string) (*initOptions, error) {
+	opts := &initOptions{chaincodeConfig: defaultConfig()}
+	if err := decodeStrict(options, opts); err != nil {
+		return nil, newArgumentError(codeInvalidArgument, "options", "Invalid Init options: %s", err)
+	}
+	cfg := opts.chaincodeConfig
+	if cfg.Scale < 0 || cfg.Scale > maxAmountScale {
+		return nil, newArgumentError(codeInvalidArgument, "options", "Invalid Init options: scale must be between 0 and %d", maxAmountScale)
+	}
+	if cfg.KYC != nil && cfg.KYC.Chaincode == "" {
+		return nil, newArgumentError(codeInvalidArgument, "options", "Invalid Init options: kyc must name a chaincode")
+	}
+	if cfg.Requests != nil {
+		if err := cfg.Requests.validate(); err != nil {
+			return nil, newArgumentError(codeInvalidArgument, "options", "Invalid Init options: %s", err)
+		}
+	}
+	if cfg.Approvals != nil {
+		if err := cfg.Approvals.validate(); err != nil {
+			return nil, newArgumentError(codeInvalidArgument, "options", "Invalid Init options: %s", err)
+		}
+	}
+	return opts, nil
+}
+
+func configKey(stub shim.ChaincodeStubInterface) (string, error) {
+	return stub.CreateCompositeKey(configObjectType, []string{"chaincode"})
+}
+
+// getConfig reads the deployment settings, falling back to the defaults for
+// deployments initialized before settings were stored.
+func getConfig(stub shim.ChaincodeStubInterface) (*chaincodeConfig, error) {
+	cfg, err := getStoredConfig(stub)
+	if err != nil || cfg != nil {
+		return cfg, err
+	}
+	return defaultConfig(), nil
+}
+
+// getStoredConfig reads the deployment settings, or nil if none were stored.
+func getStoredConfig(stub shim.ChaincodeStubInterface) (*chaincodeConfig, error) {
+	key, err := configKey(stub)
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get chaincode configuration")
+	}
+	if value == nil {
+		return nil, nil
+	}
+	cfg := &chaincodeConfig{}
+	if err := json.Unmarshal(value, cfg); err != nil {
+		return nil, fmt.Errorf("Corrupt chaincode configuration: %s", err)
+	}
+	return cfg, nil
+}
+
+// putConfig stores the deployment settings.
+func putConfig(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig) error {
+	key, err := configKey(stub)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, value)
+}
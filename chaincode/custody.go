@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// lockFunds takes X out of account A to be held for B, as escrowOpen and
+// htlcLock do, and returns A as written. Both accounts must be live and not
+// frozen, the caller must own A, A must have X available, and the payment
+// must pass KYC and A's policy. Amounts that would need approval as a
+// transfer are refused, since a lock cannot wait for a checker. verb names
+// the operation in errors. The caller records the funds with trackBalance
+// under the holder it creates.
+func lockFunds(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, A, B string, X Amount, verb string) (*account, error) {
+	Aacct, err := loadLiveAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.needsApproval(Aacct.Currency, X) {
+		return nil, newCodedError(codeApprovalRequired, "Cannot %s %s for %s without approval, make it with transfer", verb, X, B)
+	}
+	err = requireUnfrozen(Aacct)
+	if err != nil {
+		return nil, err
+	}
+	err = requireOwner(stub, cfg, Aacct)
+	if err != nil {
+		return nil, err
+	}
+	Bacct, err := loadLiveAccount(stub, B)
+	if err != nil {
+		return nil, err
+	}
+	err = requireUnfrozen(Bacct)
+	if err != nil {
+		return nil, err
+	}
+	available, err := availableBalance(stub, Aacct, Aacct.Currency, Aacct.Balance)
+	if err != nil {
+		return nil, err
+	}
+	if available.Cmp(X) < 0 {
+		return nil, newCodedError(codeInsufficientFunds, "%s has %s available, cannot %s %s", A, available, verb, X)
+	}
+	a, err := getAsset(stub, cfg, Aacct.Currency)
+	if err != nil {
+		return nil, err
+	}
+	err = requireKYC(stub, cfg, A, B)
+	if err != nil {
+		return nil, err
+	}
+	err = enforcePolicy(stub, Aacct, a, debit{to: B, amount: X})
+	if err != nil {
+		return nil, err
+	}
+
+	Aacct.Balance = Aacct.Balance.Sub(X)
+	err = putAccount(stub, Aacct)
+	if err != nil {
+		return nil, err
+	}
+	return Aacct, nil
+}
+
+// releaseFunds pays amount of symbol, held by h, to account id and records
+// that h no longer holds it. id must be live and not frozen, even when the
+// funds return to it. An empty symbol pays in the currency of id. When the
+// funds are a refund, refundOf is the transaction that took them out of id,
+// and its outflow no longer counts against the daily cap of id.
+func releaseFunds(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, h holder, id, symbol string, amount Amount, refundOf string) error {
+	acct, err := loadLiveAccount(stub, id)
+	if err != nil {
+		return err
+	}
+	err = requireUnfrozen(acct)
+	if err != nil {
+		return err
+	}
+	if symbol == "" {
+		symbol = acct.Currency
+	}
+	a, err := getAsset(stub, cfg, symbol)
+	if err != nil {
+		return err
+	}
+	balance, err := balanceOf(stub, acct, a)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s paid to %s\n", amount, id)
+
+	err = setBalance(stub, acct, a, balance.Add(amount))
+	if err != nil {
+		return err
+	}
+	trackBalance(stub, h, symbol, amount, Amount{})
+	if refundOf != "" {
+		return dropOutflow(stub, id, symbol, refundOf)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// argKind says how an argument is checked before its handler runs.
+type argKind int
+
+const (
+	argName   argKind = iota // non-empty entity or record name
+	argAmount                // decimal amount, range checks are left to the handler
+	argJSON                  // JSON document
+	argCount                 // positive integer
+	argTime                  // RFC 3339 timestamp
+	argHex                   // non-empty hex-encoded bytes
+	argHash                  // hex-encoded SHA-256 digest
+)
+
+// argSpec names and types one positional argument.
+type argSpec struct {
+	name string
+	kind argKind
+}
+
+// handlerFunc implements a chaincode function once its arguments are checked.
+type handlerFunc func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) ([]byte, error)
+
+// chaincodeFunction describes the arguments and handler of one function.
+type chaincodeFunction struct {
+	// readOnly functions answer queries and run against a stub that
+	// refuses writes.
+	readOnly bool
+	args     []argSpec
+	// optional is the number of trailing args that may be left out.
+	optional int
+	// variadic lets the last arg repeat any number of times.
+	variadic bool
+	handler  handlerFunc
+}
+
+// registry maps function names to their descriptions.
+type registry map[string]*chaincodeFunction
+
+// names returns the registered function names in a stable order.
+func (r registry) names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dispatch checks args against the function's description and runs it.
+func (r registry) dispatch(t *SimpleChaincode, stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	fn, ok := r[function]
+	if !ok {
+		return nil, newCodedError(codeUnknownFunction, "Unknown function %q. Expecting one of: %s", function, strings.Join(r.names(), ", "))
+	}
+	if err := fn.checkArgs(function, args); err != nil {
+		return nil, err
+	}
+	if fn.readOnly {
+		return fn.handler(t, readOnlyStub{stub}, args)
+	}
+	tracked := newSupplyStub(stub, function)
+	payload, err := fn.handler(t, tracked, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := tracked.finish(); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// usage renders the argument list, e.g. "from, to, amount".
+func (fn *chaincodeFunction) usage() string {
+	names := make([]string, len(fn.args))
+	for i, spec := range fn.args {
+		names[i] = spec.name
+		if i >= len(fn.args)-fn.optional {
+			names[i] = "[" + names[i] + "]"
+		}
+		if fn.variadic && i == len(fn.args)-1 {
+			names[i] += "..."
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func (fn *chaincodeFunction) checkArgs(function string, args []string) error {
+	min := len(fn.args) - fn.optional
+	if len(args) < min || (!fn.variadic && len(args) > len(fn.args)) {
+		return newCodedError(codeBadArity, "Incorrect number of arguments for %s. Expecting %s", function, fn.usage())
+	}
+
+	for i, arg := range args {
+		spec := fn.args[len(fn.args)-1]
+		if i < len(fn.args) {
+			spec = fn.args[i]
+		}
+		switch spec.kind {
+		case argName:
+			if arg == "" {
+				return newArgumentError(codeInvalidArgument, spec.name, "Argument %s of %s must not be empty", spec.name, function)
+			}
+		case argAmount:
+			if _, err := parseAmount(arg); err != nil {
+				return newArgumentError(codeInvalidAmount, spec.name, "Argument %s of %s must be a decimal amount, got %q", spec.name, function, arg)
+			}
+		case argJSON:
+			if !json.Valid([]byte(arg)) {
+				return newArgumentError(codeInvalidArgument, spec.name, "Argument %s of %s must be a JSON document", spec.name, function)
+			}
+		case argCount:
+			if n, err := strconv.Atoi(arg); err != nil || n < 1 {
+				return newArgumentError(codeInvalidArgument, spec.name, "Argument %s of %s must be a positive integer, got %q", spec.name, function, arg)
+			}
+		case argTime:
+			if _, err := time.Parse(time.RFC3339, arg); err != nil {
+				return newArgumentError(codeInvalidArgument, spec.name, "Argument %s of %s must be an RFC 3339 time, got %q", spec.name, function, arg)
+			}
+		case argHex:
+			if b, err := hex.DecodeString(arg); err != nil || len(b) == 0 {
+				return newArgumentError(codeInvalidArgument, spec.name, "Argument %s of %s must be hex-encoded bytes", spec.name, function)
+			}
+		case argHash:
+			if b, err := hex.DecodeString(arg); err != nil || len(b) != sha256.Size {
+				return newArgumentError(codeInvalidArgument, spec.name, "Argument %s of %s must be a hex-encoded SHA-256 digest", spec.name, function)
+			}
+		}
+	}
+	return nil
+}
+
+// errReadOnly is returned when a read-only function tries to change state.
+var errReadOnly = errors.New("Read-only functions cannot change the ledger")
+
+// readOnlyStub wraps the stub handed to read-only functions so that a query
+// can never leave a write set behind.
+type readOnlyStub struct {
+	shim.ChaincodeStubInterface
+}
+
+func (readOnlyStub) PutState(key string, value []byte) error {
+	return errReadOnly
+}
+
+func (readOnlyStub) DelState(key string) error {
+	return errReadOnly
+}
+
+func (readOnlyStub) PutPrivateData(collection, key string, value []byte) error {
+	return errReadOnly
+}
+
+func (readOnlyStub) DelPrivateData(collection, key string) error {
+	return errReadOnly
+}
+
+func (readOnlyStub) SetEvent(name string, payload []byte) error {
+	return errReadOnly
+}
+
+func (readOnlyStub) SetStateValidationParameter(key string, ep []byte) error {
+	return errReadOnly
+}
+
+func (readOnlyStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	return errReadOnly
+}
@@ -0,0 +1,131 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Error codes for rejected requests. Clients match on these, so they must
+// never change once released.
+const (
+	codeEntityNotFound    = "ENTITY_NOT_FOUND"
+	codeEscrowNotFound    = "ESCROW_NOT_FOUND"
+	codeHTLCNotFound      = "HTLC_NOT_FOUND"
+	codeApprovalNotFound  = "APPROVAL_NOT_FOUND"
+	codeInvalidAmount     = "INVALID_AMOUNT"
+	codeNegativeAmount    = "NEGATIVE_AMOUNT"
+	codeZeroAmount        = "ZERO_AMOUNT"
+	codeInsufficientFunds = "INSUFFICIENT_FUNDS"
+	codeSelfTransfer      = "SELF_TRANSFER"
+	codeAccountExists     = "ACCOUNT_EXISTS"
+	codeUnauthorized      = "UNAUTHORIZED"
+	codeUnknownFunction   = "UNKNOWN_FUNCTION"
+	codeBadArity          = "BAD_ARITY"
+	codeInvalidArgument   = "INVALID_ARGUMENT"
+	codeEscrowExists      = "ESCROW_EXISTS"
+	codeInvalidState      = "INVALID_STATE"
+	codeExpired           = "EXPIRED"
+	codeNotExpired        = "NOT_EXPIRED"
+	codeHTLCExists        = "HTLC_EXISTS"
+	codeInvalidPreimage   = "INVALID_PREIMAGE"
+	codeUnknownAsset      = "UNKNOWN_ASSET"
+	codeAssetExists       = "ASSET_EXISTS"
+	codeAccountDeleted    = "ACCOUNT_DELETED"
+	codeAccountFrozen     = "ACCOUNT_FROZEN"
+	codeHoldNotFound      = "HOLD_NOT_FOUND"
+	codeHoldExists        = "HOLD_EXISTS"
+	codeRequestIDReused   = "REQUEST_ID_REUSED"
+	codeApprovalRequired  = "APPROVAL_REQUIRED"
+
+	// Spending policy rejections.
+	codeTransferLimitExceeded  = "TRANSFER_LIMIT_EXCEEDED"
+	codeDailyLimitExceeded     = "DAILY_LIMIT_EXCEEDED"
+	codeCounterpartyNotAllowed = "COUNTERPARTY_NOT_ALLOWED"
+	codeCounterpartyDenied     = "COUNTERPARTY_DENIED"
+
+	// codeKYCNotApproved rejects a payment to or from an entity the KYC
+	// registry has not approved.
+	codeKYCNotApproved = "KYC_NOT_APPROVED"
+
+	// Failures the caller cannot correct. codeInternal covers every error
+	// that is not a codedError, such as ledger and corrupt state errors.
+	codeInternal               = "INTERNAL_ERROR"
+	codePrivateDataUnavailable = "PRIVATE_DATA_UNAVAILABLE"
+	codeKYCUnavailable         = "KYC_UNAVAILABLE"
+)
+
+// codedError is an error that callers can tell apart by its code. Its
+// JSON encoding is the message of every failed response.
+type codedError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Argument names the offending argument, when there is one.
+	Argument string `json:"argument,omitempty"`
+}
+
+func (e *codedError) Error() string {
+	value, _ := json.Marshal(e)
+	return string(value)
+}
+
+// status is the response status for e: 400 for rejections the caller can
+// correct, 500 for everything else.
+func (e *codedError) status() int32 {
+	switch e.Code {
+	case codeInternal, codePrivateDataUnavailable, codeKYCUnavailable:
+		return shim.ERROR
+	}
+	return shim.ERRORTHRESHOLD
+}
+
+// newCodedError builds a codedError with a formatted message.
+func newCodedError(code, format string, args ...interface{}) error {
+	return &codedError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// newArgumentError is newCodedError blaming the named argument.
+func newArgumentError(code, argument, format string, args ...interface{}) error {
+	return &codedError{Code: code, Message: fmt.Sprintf(format, args...), Argument: argument}
+}
+
+// withArgument blames the named argument for err, unless err is internal or
+// already names one.
+func withArgument(err error, argument string) error {
+	if coded, ok := err.(*codedError); ok && coded.Argument == "" && coded.status() != shim.ERROR {
+		coded.Argument = argument
+	}
+	return err
+}
+
+// respond turns a handler result into a peer response. Errors that are not
+// coded are reported as internal.
+func respond(payload []byte, err error) pb.Response {
+	if err == nil {
+		return shim.Success(payload)
+	}
+	fmt.Printf("Error: %s\n", err)
+	coded, ok := err.(*codedError)
+	if !ok {
+		coded = &codedError{Code: codeInternal, Message: err.Error()}
+	}
+	return pb.Response{Status: coded.status(), Message: coded.Error()}
+}
@@ -0,0 +1,318 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const escrowObjectType = "escrow"
+
+// Escrow states. Only an open escrow holds funds; every other state is final.
+const (
+	escrowOpen     = "OPEN"
+	escrowReleased = "RELEASED"
+	escrowRefunded = "REFUNDED"
+	escrowExpired  = "EXPIRED"
+)
+
+// escrow is funds taken from a payer and held until they are released to
+// the beneficiary, or refunded to the payer.
+type escrow struct {
+	ID          string `json:"id"`
+	Payer       string `json:"payer"`
+	Beneficiary string `json:"beneficiary"`
+	// Arbiter is the identity that may settle the escrow either way.
+	Arbiter string `json:"arbiter"`
+	Amount  Amount `json:"amount"`
+	Expiry  string `json:"expiry"`
+	State   string `json:"state"`
+	// OpenedBy is the identity that funded the escrow from the payer.
+	OpenedBy string `json:"openedBy"`
+	OpenedTx string `json:"openedTx"`
+	ClosedTx string `json:"closedTx,omitempty"`
+}
+
+// escrowEvent is emitted whenever an escrow changes state.
+type escrowEvent struct {
+	eventHeader
+	Escrow *escrow `json:"escrow"`
+}
+
+// txTime returns the timestamp the client put on the transaction. Every
+// endorser sees the same value, unlike the local clock.
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to get transaction timestamp: %s", err)
+	}
+	return ptypes.Timestamp(ts)
+}
+
+func escrowKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	return stub.CreateCompositeKey(escrowObjectType, []string{id})
+}
+
+// getEscrow reads the escrow stored under id, or nil if there is none.
+func getEscrow(stub shim.ChaincodeStubInterface, id string) (*escrow, error) {
+	key, err := escrowKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state for escrow " + id)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	e := &escrow{}
+	if err := json.Unmarshal(value, e); err != nil {
+		return nil, fmt.Errorf("Corrupt state for escrow %s: %s", id, err)
+	}
+	return e, nil
+}
+
+func putEscrow(stub shim.ChaincodeStubInterface, e *escrow) error {
+	key, err := escrowKey(stub, e.ID)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, value)
+}
+
+// loadOpenEscrow reads an escrow that must exist and still hold its funds.
+func loadOpenEscrow(stub shim.ChaincodeStubInterface, id string) (*escrow, error) {
+	e, err := getEscrow(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, newArgumentError(codeEscrowNotFound, "id", "Escrow %s not found", id)
+	}
+	if e.State != escrowOpen {
+		return nil, newCodedError(codeInvalidState, "Escrow %s is already %s", id, e.State)
+	}
+	return e, nil
+}
+
+// Debits the payer into a new escrow: id, payer, beneficiary, arbiter,
+// amount, expiry. The expiry is an RFC 3339 time compared against
+// transaction timestamps.
+func (t *SimpleChaincode) escrowOpen(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	id, A, B, arbiter := args[0], args[1], args[2], args[3]
+	if A == B {
+		return nil, newCodedError(codeSelfTransfer, "Cannot escrow from %s to itself", A)
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	X, err := parseTransferAmount(args[4], cfg.Scale)
+	if err != nil {
+		return nil, err
+	}
+	expiry, err := time.Parse(time.RFC3339, args[5])
+	if err != nil {
+		return nil, newArgumentError(codeInvalidArgument, "expiry", "Argument expiry of escrowOpen must be an RFC 3339 time, got %q", args[5])
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !expiry.After(now) {
+		return nil, newCodedError(codeExpired, "Escrow expiry %s is not in the future", args[5])
+	}
+
+	existing, err := getEscrow(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, newCodedError(codeEscrowExists, "Escrow %s already exists", id)
+	}
+
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	Aacct, err := lockFunds(stub, cfg, A, B, X, "escrow")
+	if err != nil {
+		return nil, err
+	}
+
+	e := &escrow{
+		ID:          id,
+		Payer:       A,
+		Beneficiary: B,
+		Arbiter:     arbiter,
+		Amount:      X,
+		Expiry:      expiry.UTC().Format(time.RFC3339Nano),
+		State:       escrowOpen,
+		OpenedBy:    caller,
+		OpenedTx:    stub.GetTxID(),
+	}
+	fmt.Printf("Escrow %s holds %s from %s for %s\n", id, X, A, B)
+
+	err = putEscrow(stub, e)
+	if err != nil {
+		return nil, err
+	}
+	trackBalance(stub, holder{kind: holderEscrow, id: e.ID}, Aacct.Currency, Amount{}, X)
+
+	err = setEvent(stub, eventEscrowOpened, &escrowEvent{eventHeader: newEventHeader(stub), Escrow: e})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Pays an open escrow out to its beneficiary. Only the identity that opened
+// it or the arbiter may release it, and only before it expires.
+func (t *SimpleChaincode) escrowRelease(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	e, err := loadOpenEscrow(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller != e.OpenedBy && caller != e.Arbiter {
+		return nil, newCodedError(codeUnauthorized, "Only the payer or the arbiter may release escrow %s", e.ID)
+	}
+	expired, err := e.expired(stub)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, newCodedError(codeExpired, "Escrow %s expired at %s", e.ID, e.Expiry)
+	}
+
+	return settleEscrow(stub, e, e.Beneficiary, escrowReleased, eventEscrowReleased)
+}
+
+// Returns an open escrow to its payer. The arbiter, or the owner of the
+// beneficiary account waiving the payment, may refund it.
+func (t *SimpleChaincode) escrowRefund(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	e, err := loadOpenEscrow(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller != e.Arbiter {
+		Bacct, err := loadAccount(stub, e.Beneficiary)
+		if err != nil {
+			return nil, err
+		}
+		if Bacct.Owner == "" || caller != Bacct.Owner {
+			return nil, newCodedError(codeUnauthorized, "Only the arbiter or the beneficiary may refund escrow %s", e.ID)
+		}
+	}
+
+	return settleEscrow(stub, e, e.Payer, escrowRefunded, eventEscrowRefunded)
+}
+
+// Returns an escrow that has passed its expiry to the payer. Anyone may call
+// it, so funds never stay locked because a party went away.
+func (t *SimpleChaincode) escrowExpire(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	e, err := loadOpenEscrow(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	expired, err := e.expired(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !expired {
+		return nil, newCodedError(codeNotExpired, "Escrow %s does not expire until %s", e.ID, e.Expiry)
+	}
+
+	return settleEscrow(stub, e, e.Payer, escrowExpired, eventEscrowExpired)
+}
+
+// Returns an escrow record.
+func (t *SimpleChaincode) escrowQuery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	e, err := getEscrow(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, newArgumentError(codeEscrowNotFound, "id", "Escrow %s not found", args[0])
+	}
+	return json.Marshal(e)
+}
+
+// expired reports whether the transaction is at or past the escrow expiry.
+func (e *escrow) expired(stub shim.ChaincodeStubInterface) (bool, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return false, err
+	}
+	expiry, err := time.Parse(time.RFC3339, e.Expiry)
+	if err != nil {
+		return false, fmt.Errorf("Corrupt state for escrow %s: %s", e.ID, err)
+	}
+	return !now.Before(expiry), nil
+}
+
+// settleEscrow credits the escrowed funds to id and closes the escrow in the
+// given final state.
+func settleEscrow(stub shim.ChaincodeStubInterface, e *escrow, id, state, eventName string) ([]byte, error) {
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	var refundOf string
+	if id == e.Payer {
+		refundOf = e.OpenedTx
+	}
+	err = releaseFunds(stub, cfg, holder{kind: holderEscrow, id: e.ID}, id, "", e.Amount, refundOf)
+	if err != nil {
+		return nil, err
+	}
+	e.State = state
+	e.ClosedTx = stub.GetTxID()
+	fmt.Printf("Escrow %s %s\n", e.ID, state)
+
+	err = putEscrow(stub, e)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventName, &escrowEvent{eventHeader: newEventHeader(stub), Escrow: e})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
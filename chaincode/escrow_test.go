@@ -0,0 +1,166 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// escrowFixture opens escrow e1 of 30 from A to C, a beneficiary owned by
+// alice, with arbiter as the arbiter and an expiry one hour out.
+type escrowFixture struct {
+	*fixture
+	arbiter *identity
+	expiry  time.Time
+}
+
+func newEscrowFixture(t *testing.T) *escrowFixture {
+	f := &escrowFixture{
+		fixture: newFixture(t),
+		arbiter: newIdentity(t, "Org2MSP", "arbiter"),
+	}
+	f.expiry = f.now.Add(time.Hour)
+	checkOK(t, f.invoke(f.alice, "create", "C"))
+	checkOK(t, f.invoke(f.admin, "escrowOpen", "e1", "A", "C", f.id(t, f.arbiter), "30", f.expiry.Format(time.RFC3339)))
+	f.checkBalance(t, "A", "70")
+	return f
+}
+
+func (f *escrowFixture) checkState(t *testing.T, want string) {
+	t.Helper()
+	res := f.invoke(f.alice, "escrow", "e1")
+	checkOK(t, res)
+	var e escrow
+	if err := json.Unmarshal(res.Payload, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.State != want {
+		t.Fatalf("escrow is %s, expected %s", e.State, want)
+	}
+}
+
+func TestEscrowRelease(t *testing.T) {
+	for _, releaser := range []string{"payer", "arbiter"} {
+		t.Run(releaser, func(t *testing.T) {
+			f := newEscrowFixture(t)
+			caller := f.admin
+			if releaser == "arbiter" {
+				caller = f.arbiter
+			}
+			checkFailed(t, f.invoke(f.alice, "escrowRelease", "e1"), shim.ERRORTHRESHOLD, codeUnauthorized)
+			checkOK(t, f.invoke(caller, "escrowRelease", "e1"))
+			f.checkEvent(t, eventEscrowReleased)
+			f.checkState(t, escrowReleased)
+			f.checkBalance(t, "A", "70")
+			f.checkBalance(t, "C", "30")
+
+			checkFailed(t, f.invoke(caller, "escrowRelease", "e1"), shim.ERRORTHRESHOLD, codeInvalidState)
+			checkFailed(t, f.invoke(f.arbiter, "escrowRefund", "e1"), shim.ERRORTHRESHOLD, codeInvalidState)
+		})
+	}
+}
+
+func TestEscrowRefund(t *testing.T) {
+	for _, refunder := range []string{"beneficiary", "arbiter"} {
+		t.Run(refunder, func(t *testing.T) {
+			f := newEscrowFixture(t)
+			caller := f.alice
+			if refunder == "arbiter" {
+				caller = f.arbiter
+			}
+			checkFailed(t, f.invoke(f.admin, "escrowRefund", "e1"), shim.ERRORTHRESHOLD, codeUnauthorized)
+			checkOK(t, f.invoke(caller, "escrowRefund", "e1"))
+			f.checkState(t, escrowRefunded)
+			f.checkBalance(t, "A", "100")
+			f.checkBalance(t, "C", "0")
+		})
+	}
+}
+
+func TestRefundedEscrowLeavesDailyCap(t *testing.T) {
+	f := newEscrowFixture(t)
+	checkOK(t, f.invoke(f.admin, "setPolicy", "A", `{"limits":{"UNIT":{"dailyCap":"50"}}}`))
+	checkOK(t, f.invoke(f.admin, "escrowOpen", "e2", "A", "C", f.id(t, f.arbiter), "40", f.expiry.Format(time.RFC3339)))
+	checkOK(t, f.invoke(f.arbiter, "escrowRefund", "e2"))
+
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "50"))
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1"), shim.ERRORTHRESHOLD, codeDailyLimitExceeded)
+}
+
+func TestEscrowExpire(t *testing.T) {
+	f := newEscrowFixture(t)
+	checkFailed(t, f.invoke(f.alice, "escrowExpire", "e1"), shim.ERRORTHRESHOLD, codeNotExpired)
+
+	f.now = f.expiry
+	checkFailed(t, f.invoke(f.arbiter, "escrowRelease", "e1"), shim.ERRORTHRESHOLD, codeExpired)
+	checkOK(t, f.invoke(f.alice, "escrowExpire", "e1"))
+	f.checkState(t, escrowExpired)
+	f.checkBalance(t, "A", "100")
+	f.checkBalance(t, "C", "0")
+}
+
+func TestEscrowExpiryKeepsFractionalSeconds(t *testing.T) {
+	f := newEscrowFixture(t)
+	expiry := f.now.Add(1500 * time.Millisecond)
+	checkOK(t, f.invoke(f.admin, "escrowOpen", "e2", "A", "C", f.id(t, f.arbiter), "5", expiry.Format(time.RFC3339Nano)))
+
+	f.now = f.now.Add(time.Second)
+	checkFailed(t, f.invoke(f.alice, "escrowExpire", "e2"), shim.ERRORTHRESHOLD, codeNotExpired)
+	f.now = expiry
+	checkOK(t, f.invoke(f.alice, "escrowExpire", "e2"))
+}
+
+func TestEscrowOpen(t *testing.T) {
+	f := newEscrowFixture(t)
+	later := f.expiry.Format(time.RFC3339)
+	tests := []struct {
+		name    string
+		caller  *identity
+		args    []string
+		status  int32
+		message string
+	}{
+		{name: "duplicate id", caller: f.admin, args: []string{"e1", "A", "C", "arb", "1", later}, status: shim.ERRORTHRESHOLD, message: codeEscrowExists},
+		{name: "past expiry", caller: f.admin, args: []string{"e2", "A", "C", "arb", "1", f.now.Format(time.RFC3339)}, status: shim.ERRORTHRESHOLD, message: codeExpired},
+		{name: "bad expiry", caller: f.admin, args: []string{"e2", "A", "C", "arb", "1", "tomorrow"}, status: shim.ERRORTHRESHOLD, message: codeInvalidArgument},
+		{name: "overdraft", caller: f.admin, args: []string{"e2", "A", "C", "arb", "71", later}, status: shim.ERRORTHRESHOLD, message: codeInsufficientFunds},
+		{name: "not payer owner", caller: f.alice, args: []string{"e2", "A", "C", "arb", "1", later}, status: shim.ERRORTHRESHOLD, message: codeUnauthorized},
+		{name: "missing beneficiary", caller: f.admin, args: []string{"e2", "A", "Z", "arb", "1", later}, status: shim.ERRORTHRESHOLD, message: codeEntityNotFound},
+		{name: "to payer", caller: f.admin, args: []string{"e2", "A", "A", "arb", "1", later}, status: shim.ERRORTHRESHOLD, message: codeSelfTransfer},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkFailed(t, f.invoke(test.caller, append([]string{"escrowOpen"}, test.args...)...), test.status, test.message)
+			f.checkBalance(t, "A", "70")
+		})
+	}
+	checkFailed(t, f.invoke(f.alice, "escrow", "e2"), shim.ERRORTHRESHOLD, codeEscrowNotFound)
+}
+
+func TestEscrowOpenParsesExpiry(t *testing.T) {
+	f := newFixture(t)
+	res := f.run(f.admin, func(stub shim.ChaincodeStubInterface) pb.Response {
+		return respond(f.cc.escrowOpen(stub, []string{"e1", "A", "B", "arb", "5", "soon"}))
+	}, nil)
+	checkFailed(t, res, shim.ERRORTHRESHOLD, `"argument":"expiry"`)
+	f.checkBalance(t, "A", "100")
+}
@@ -0,0 +1,148 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// eventSchemaVersion is carried by every event payload. Bump it whenever a
+// payload changes in a way existing subscribers would misread.
+//
+// Version 2 leaves out the balances of private accounts. Version 3 names the
+// asset of every transfer. Version 4 leaves out the amount of transfers with
+// a private account.
+const eventSchemaVersion = 4
+
+// Chaincode event names. A transaction carries at most one event, so every
+// mutating function sets exactly one.
+const (
+	eventAccountsCreated = "AccountsCreated"
+	eventTransfer        = "Transfer"
+	eventAccountDeleted  = "AccountDeleted"
+	eventBatchTransfer   = "BatchTransfer"
+	eventEscrowOpened    = "EscrowOpened"
+	eventEscrowReleased  = "EscrowReleased"
+	eventEscrowRefunded  = "EscrowRefunded"
+	eventEscrowExpired   = "EscrowExpired"
+	eventHTLCLocked      = "HTLCLocked"
+	eventHTLCClaimed     = "HTLCClaimed"
+	eventHTLCRefunded    = "HTLCRefunded"
+	eventAssetRegistered = "AssetRegistered"
+	eventMinted          = "Minted"
+	eventBurned          = "Burned"
+	eventAccountRestored = "AccountRestored"
+	eventPolicyChanged   = "PolicyChanged"
+	eventRequestsExpired = "RequestsExpired"
+	eventBlockHeight     = "BlockHeightChanged"
+	eventScheduleChanged = "ScheduleChanged"
+	eventAccrued         = "Accrued"
+
+	// Maker/checker approval of large transfers.
+	eventTransferPending   = "TransferPending"
+	eventTransferApproved  = "TransferApproved"
+	eventTransferRejected  = "TransferRejected"
+	eventTransferExpired   = "TransferExpired"
+	eventApprovalThreshold = "ApprovalThresholdChanged"
+
+	// Compliance actions, each carrying a reason code.
+	eventAccountFrozen   = "AccountFrozen"
+	eventAccountUnfrozen = "AccountUnfrozen"
+	eventHoldPlaced      = "HoldPlaced"
+	eventHoldReleased    = "HoldReleased"
+)
+
+// eventHeader is embedded in every event payload.
+type eventHeader struct {
+	Version int    `json:"version"`
+	TxID    string `json:"txid"`
+}
+
+func newEventHeader(stub shim.ChaincodeStubInterface) eventHeader {
+	return eventHeader{Version: eventSchemaVersion, TxID: stub.GetTxID()}
+}
+
+// openedAccount is one account in an AccountsCreated event.
+type openedAccount struct {
+	ID      string  `json:"id"`
+	Balance *Amount `json:"balance,omitempty"`
+}
+
+// accountsCreatedEvent is emitted by Init, create and createBatch.
+type accountsCreatedEvent struct {
+	eventHeader
+	Accounts []openedAccount `json:"accounts"`
+}
+
+// transferEvent is emitted when funds move between two accounts.
+type transferEvent struct {
+	eventHeader
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Amount is left out when either account is private.
+	Amount  *Amount `json:"amount,omitempty"`
+	Asset   string  `json:"asset"`
+	Private bool    `json:"private,omitempty"`
+}
+
+// accountDeletedEvent is emitted when an account is tombstoned, and
+// accountRestoredEvent when it is brought back.
+type accountDeletedEvent struct {
+	eventHeader
+	ID      string  `json:"id"`
+	Balance *Amount `json:"balance,omitempty"`
+	// Holdings are the balances the account holds in other assets.
+	Holdings []heldAsset `json:"holdings,omitempty"`
+}
+
+type accountRestoredEvent accountDeletedEvent
+
+// heldAsset is an account's balance in one asset.
+type heldAsset struct {
+	Asset   string `json:"asset"`
+	Balance Amount `json:"balance"`
+}
+
+// newAccountsCreatedEvent describes the opening of accts.
+func newAccountsCreatedEvent(stub shim.ChaincodeStubInterface, accts ...*account) *accountsCreatedEvent {
+	event := &accountsCreatedEvent{eventHeader: newEventHeader(stub)}
+	for _, acct := range accts {
+		event.Accounts = append(event.Accounts, openedAccount{ID: acct.ID, Balance: publicBalance(acct)})
+	}
+	return event
+}
+
+// publicBalance is the balance of acct as events may show it: nil for a
+// private account, whose balance every channel member would otherwise see.
+func publicBalance(acct *account) *Amount {
+	if acct.Private != nil {
+		return nil
+	}
+	balance := acct.Balance
+	return &balance
+}
+
+// setEvent attaches payload to the transaction as the chaincode event name.
+func setEvent(stub shim.ChaincodeStubInterface, name string, payload interface{}) error {
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(name, value)
+}
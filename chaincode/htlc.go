@@ -0,0 +1,286 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const htlcObjectType = "htlc"
+
+// HTLC states. Only a locked contract holds funds.
+const (
+	htlcLocked   = "LOCKED"
+	htlcClaimed  = "CLAIMED"
+	htlcRefunded = "REFUNDED"
+)
+
+// htlc is a hash time-locked contract: funds taken from a sender that the
+// recipient can claim by revealing the preimage of Hashlock before Timelock,
+// and that return to the sender after it. The same hashlock on another
+// ledger makes the two transfers an atomic swap.
+type htlc struct {
+	ID        string `json:"id"`
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	Amount    Amount `json:"amount"`
+	// Hashlock is the hex-encoded SHA-256 digest of the preimage.
+	Hashlock string `json:"hashlock"`
+	Timelock string `json:"timelock"`
+	State    string `json:"state"`
+	// Preimage is published by the claim so the counterparty can use it
+	// on the other ledger.
+	Preimage string `json:"preimage,omitempty"`
+	LockedTx string `json:"lockedTx"`
+	ClosedTx string `json:"closedTx,omitempty"`
+}
+
+// htlcEvent is emitted whenever an HTLC changes state.
+type htlcEvent struct {
+	eventHeader
+	HTLC *htlc `json:"htlc"`
+}
+
+func htlcKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	return stub.CreateCompositeKey(htlcObjectType, []string{id})
+}
+
+// getHTLC reads the contract stored under id, or nil if there is none.
+func getHTLC(stub shim.ChaincodeStubInterface, id string) (*htlc, error) {
+	key, err := htlcKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state for HTLC " + id)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	h := &htlc{}
+	if err := json.Unmarshal(value, h); err != nil {
+		return nil, fmt.Errorf("Corrupt state for HTLC %s: %s", id, err)
+	}
+	return h, nil
+}
+
+func putHTLC(stub shim.ChaincodeStubInterface, h *htlc) error {
+	key, err := htlcKey(stub, h.ID)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, value)
+}
+
+// loadLockedHTLC reads a contract that must exist and still hold its funds.
+func loadLockedHTLC(stub shim.ChaincodeStubInterface, id string) (*htlc, error) {
+	h, err := getHTLC(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, newArgumentError(codeHTLCNotFound, "id", "HTLC %s not found", id)
+	}
+	if h.State != htlcLocked {
+		return nil, newCodedError(codeInvalidState, "HTLC %s is already %s", id, h.State)
+	}
+	return h, nil
+}
+
+// Debits the sender into a new HTLC: id, sender, recipient, amount,
+// hashlock, timelock. The hashlock is a hex SHA-256 digest and the timelock
+// an RFC 3339 time compared against transaction timestamps.
+func (t *SimpleChaincode) htlcLock(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	id, A, B := args[0], args[1], args[2]
+	if A == B {
+		return nil, newCodedError(codeSelfTransfer, "Cannot lock funds from %s for itself", A)
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	X, err := parseTransferAmount(args[3], cfg.Scale)
+	if err != nil {
+		return nil, err
+	}
+	timelock, err := time.Parse(time.RFC3339, args[5])
+	if err != nil {
+		return nil, newArgumentError(codeInvalidArgument, "timelock", "Argument timelock of htlcLock must be an RFC 3339 time, got %q", args[5])
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !timelock.After(now) {
+		return nil, newCodedError(codeExpired, "HTLC timelock %s is not in the future", args[5])
+	}
+
+	existing, err := getHTLC(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, newCodedError(codeHTLCExists, "HTLC %s already exists", id)
+	}
+
+	Aacct, err := lockFunds(stub, cfg, A, B, X, "lock")
+	if err != nil {
+		return nil, err
+	}
+
+	h := &htlc{
+		ID:        id,
+		Sender:    A,
+		Recipient: B,
+		Amount:    X,
+		Hashlock:  strings.ToLower(args[4]),
+		Timelock:  timelock.UTC().Format(time.RFC3339Nano),
+		State:     htlcLocked,
+		LockedTx:  stub.GetTxID(),
+	}
+	fmt.Printf("HTLC %s locks %s from %s for %s\n", id, X, A, B)
+
+	err = putHTLC(stub, h)
+	if err != nil {
+		return nil, err
+	}
+	trackBalance(stub, holder{kind: holderHTLC, id: h.ID}, Aacct.Currency, Amount{}, X)
+
+	err = setEvent(stub, eventHTLCLocked, &htlcEvent{eventHeader: newEventHeader(stub), HTLC: h})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Pays a locked HTLC to its recipient given the hex-encoded preimage of its
+// hashlock. Knowing the preimage is the authorization, so anyone may claim,
+// but only before the timelock.
+func (t *SimpleChaincode) htlcClaim(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	h, err := loadLockedHTLC(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	expired, err := h.expired(stub)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, newCodedError(codeExpired, "HTLC %s timed out at %s", h.ID, h.Timelock)
+	}
+	preimage, err := hex.DecodeString(args[1])
+	if err != nil || len(preimage) == 0 {
+		return nil, newArgumentError(codeInvalidArgument, "preimage", "Argument preimage of htlcClaim must be hex-encoded bytes")
+	}
+	digest := sha256.Sum256(preimage)
+	if hex.EncodeToString(digest[:]) != h.Hashlock {
+		return nil, newCodedError(codeInvalidPreimage, "Preimage does not match the hashlock of HTLC %s", h.ID)
+	}
+	h.Preimage = strings.ToLower(args[1])
+
+	return settleHTLC(stub, h, h.Recipient, htlcClaimed, eventHTLCClaimed)
+}
+
+// Returns a locked HTLC to its sender once the timelock has passed. Anyone
+// may call it, since the funds can only go back where they came from.
+func (t *SimpleChaincode) htlcRefund(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	h, err := loadLockedHTLC(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	expired, err := h.expired(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !expired {
+		return nil, newCodedError(codeNotExpired, "HTLC %s is locked until %s", h.ID, h.Timelock)
+	}
+
+	return settleHTLC(stub, h, h.Sender, htlcRefunded, eventHTLCRefunded)
+}
+
+// Returns an HTLC record.
+func (t *SimpleChaincode) htlcQuery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	h, err := getHTLC(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, newArgumentError(codeHTLCNotFound, "id", "HTLC %s not found", args[0])
+	}
+	return json.Marshal(h)
+}
+
+// expired reports whether the transaction is at or past the timelock.
+func (h *htlc) expired(stub shim.ChaincodeStubInterface) (bool, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return false, err
+	}
+	timelock, err := time.Parse(time.RFC3339, h.Timelock)
+	if err != nil {
+		return false, fmt.Errorf("Corrupt state for HTLC %s: %s", h.ID, err)
+	}
+	return !now.Before(timelock), nil
+}
+
+// settleHTLC credits the locked funds to id and closes the contract in the
+// given final state.
+func settleHTLC(stub shim.ChaincodeStubInterface, h *htlc, id, state, eventName string) ([]byte, error) {
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	var refundOf string
+	if id == h.Sender {
+		refundOf = h.LockedTx
+	}
+	err = releaseFunds(stub, cfg, holder{kind: holderHTLC, id: h.ID}, id, "", h.Amount, refundOf)
+	if err != nil {
+		return nil, err
+	}
+	h.State = state
+	h.ClosedTx = stub.GetTxID()
+	fmt.Printf("HTLC %s %s\n", h.ID, state)
+
+	err = putHTLC(stub, h)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventName, &htlcEvent{eventHeader: newEventHeader(stub), HTLC: h})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
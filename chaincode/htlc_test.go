@@ -0,0 +1,160 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const testPreimage = "73656372657421" // "secret!"
+
+// htlcFixture locks 40 from A for B under the hash of testPreimage, with a
+// timelock one hour out.
+type htlcFixture struct {
+	*fixture
+	timelock time.Time
+}
+
+func newHTLCFixture(t *testing.T) *htlcFixture {
+	f := &htlcFixture{fixture: newFixture(t)}
+	f.timelock = f.now.Add(time.Hour)
+	checkOK(t, f.invoke(f.admin, "htlcLock", "h1", "A", "B", "40", hashOf(t, testPreimage), f.timelock.Format(time.RFC3339)))
+	f.checkBalance(t, "A", "60")
+	return f
+}
+
+func hashOf(t *testing.T, preimage string) string {
+	b, err := hex.DecodeString(preimage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(b)
+	return hex.EncodeToString(digest[:])
+}
+
+func (f *htlcFixture) get(t *testing.T) *htlc {
+	t.Helper()
+	res := f.invoke(f.alice, "htlc", "h1")
+	checkOK(t, res)
+	h := &htlc{}
+	if err := json.Unmarshal(res.Payload, h); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHTLCClaim(t *testing.T) {
+	f := newHTLCFixture(t)
+	checkFailed(t, f.invoke(f.alice, "htlcClaim", "h1", "00"), shim.ERRORTHRESHOLD, codeInvalidPreimage)
+	checkFailed(t, f.invoke(f.alice, "htlcClaim", "h1", "secret!"), shim.ERRORTHRESHOLD, codeInvalidArgument)
+	checkFailed(t, f.invoke(f.admin, "htlcRefund", "h1"), shim.ERRORTHRESHOLD, codeNotExpired)
+
+	checkOK(t, f.invoke(f.alice, "htlcClaim", "h1", testPreimage))
+	f.checkEvent(t, eventHTLCClaimed)
+	f.checkBalance(t, "A", "60")
+	f.checkBalance(t, "B", "240")
+	if h := f.get(t); h.State != htlcClaimed || h.Preimage != testPreimage {
+		t.Fatalf("expected a claimed HTLC revealing the preimage, got %+v", h)
+	}
+
+	checkFailed(t, f.invoke(f.alice, "htlcClaim", "h1", testPreimage), shim.ERRORTHRESHOLD, codeInvalidState)
+	f.now = f.timelock
+	checkFailed(t, f.invoke(f.admin, "htlcRefund", "h1"), shim.ERRORTHRESHOLD, codeInvalidState)
+}
+
+func TestHTLCRefund(t *testing.T) {
+	f := newHTLCFixture(t)
+	f.now = f.timelock
+	checkFailed(t, f.invoke(f.alice, "htlcClaim", "h1", testPreimage), shim.ERRORTHRESHOLD, codeExpired)
+
+	checkOK(t, f.invoke(f.alice, "htlcRefund", "h1"))
+	f.checkBalance(t, "A", "100")
+	f.checkBalance(t, "B", "200")
+	if h := f.get(t); h.State != htlcRefunded || h.Preimage != "" {
+		t.Fatalf("expected a refunded HTLC, got %+v", h)
+	}
+}
+
+func TestHTLCTimelockKeepsFractionalSeconds(t *testing.T) {
+	f := newHTLCFixture(t)
+	timelock := f.now.Add(1500 * time.Millisecond)
+	checkOK(t, f.invoke(f.admin, "htlcLock", "h2", "A", "B", "5", hashOf(t, testPreimage), timelock.Format(time.RFC3339Nano)))
+
+	f.now = f.now.Add(time.Second)
+	checkFailed(t, f.invoke(f.alice, "htlcRefund", "h2"), shim.ERRORTHRESHOLD, codeNotExpired)
+	f.now = timelock
+	checkOK(t, f.invoke(f.alice, "htlcRefund", "h2"))
+}
+
+func TestRefundedHTLCLeavesDailyCap(t *testing.T) {
+	f := newHTLCFixture(t)
+	checkOK(t, f.invoke(f.admin, "setPolicy", "A", `{"limits":{"UNIT":{"dailyCap":"50"}}}`))
+	checkOK(t, f.invoke(f.admin, "htlcLock", "h2", "A", "B", "40", hashOf(t, testPreimage), f.timelock.Format(time.RFC3339)))
+	f.now = f.timelock
+	checkOK(t, f.invoke(f.alice, "htlcRefund", "h2"))
+
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "50"))
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1"), shim.ERRORTHRESHOLD, codeDailyLimitExceeded)
+}
+
+func TestHTLCLock(t *testing.T) {
+	f := newHTLCFixture(t)
+	hash := hashOf(t, testPreimage)
+	later := f.timelock.Format(time.RFC3339)
+	tests := []struct {
+		name    string
+		caller  *identity
+		args    []string
+		status  int32
+		message string
+	}{
+		{name: "duplicate id", caller: f.admin, args: []string{"h1", "A", "B", "1", hash, later}, status: shim.ERRORTHRESHOLD, message: codeHTLCExists},
+		{name: "past timelock", caller: f.admin, args: []string{"h2", "A", "B", "1", hash, f.now.Format(time.RFC3339)}, status: shim.ERRORTHRESHOLD, message: codeExpired},
+		{name: "short hashlock", caller: f.admin, args: []string{"h2", "A", "B", "1", "abcd", later}, status: shim.ERRORTHRESHOLD, message: codeInvalidArgument},
+		{name: "overdraft", caller: f.admin, args: []string{"h2", "A", "B", "61", hash, later}, status: shim.ERRORTHRESHOLD, message: codeInsufficientFunds},
+		{name: "not sender owner", caller: f.alice, args: []string{"h2", "A", "B", "1", hash, later}, status: shim.ERRORTHRESHOLD, message: codeUnauthorized},
+		{name: "missing recipient", caller: f.admin, args: []string{"h2", "A", "Z", "1", hash, later}, status: shim.ERRORTHRESHOLD, message: codeEntityNotFound},
+		{name: "to sender", caller: f.admin, args: []string{"h2", "A", "A", "1", hash, later}, status: shim.ERRORTHRESHOLD, message: codeSelfTransfer},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkFailed(t, f.invoke(test.caller, append([]string{"htlcLock"}, test.args...)...), test.status, test.message)
+			f.checkBalance(t, "A", "60")
+		})
+	}
+	checkFailed(t, f.invoke(f.alice, "htlc", "h2"), shim.ERRORTHRESHOLD, codeHTLCNotFound)
+}
+
+// The handlers check their own arguments rather than trusting the dispatcher.
+func TestHTLCHandlersParseTheirArguments(t *testing.T) {
+	f := newHTLCFixture(t)
+	direct := func(handler func(*SimpleChaincode, shim.ChaincodeStubInterface, []string) ([]byte, error), args ...string) pb.Response {
+		return f.run(f.alice, func(stub shim.ChaincodeStubInterface) pb.Response {
+			return respond(handler(f.cc, stub, args))
+		}, nil)
+	}
+	checkFailed(t, direct((*SimpleChaincode).htlcLock, "h2", "A", "B", "1", hashOf(t, testPreimage), "soon"), shim.ERRORTHRESHOLD, codeInvalidArgument)
+	checkFailed(t, direct((*SimpleChaincode).htlcClaim, "h1", "secret!"), shim.ERRORTHRESHOLD, codeInvalidArgument)
+	f.checkBalance(t, "B", "200")
+}
@@ -0,0 +1,122 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// callerIdentity names the submitter of the current transaction as
+// "<MSP ID>/x509::<subject DN>::<issuer DN>", taken from the creator's
+// certificate. Account owners and admins are recorded in this form.
+func callerIdentity(stub shim.ChaincodeStubInterface) (string, error) {
+	client, err := cid.New(stub)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read transaction creator: %s", err)
+	}
+	mspID, err := client.GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("Failed to read transaction creator: %s", err)
+	}
+	encoded, err := client.GetID()
+	if err != nil {
+		return "", fmt.Errorf("Failed to read transaction creator: %s", err)
+	}
+	id, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read transaction creator: %s", err)
+	}
+	return mspID + "/" + string(id), nil
+}
+
+// isAdmin reports whether identity holds the admin role.
+func (cfg *chaincodeConfig) isAdmin(identity string) bool {
+	for _, admin := range cfg.Admins {
+		if admin == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAdmin fails with an authorization error unless the caller is an
+// admin. action describes the refused operation.
+func requireAdmin(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, action string) error {
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return err
+	}
+	if !cfg.isAdmin(caller) {
+		return newCodedError(codeUnauthorized, "%s may only be done by an admin", action)
+	}
+	return nil
+}
+
+// isCompliance reports whether identity holds the compliance role.
+func (cfg *chaincodeConfig) isCompliance(identity string) bool {
+	if len(cfg.Compliance) == 0 {
+		return cfg.isAdmin(identity)
+	}
+	return contains(cfg.Compliance, identity)
+}
+
+// requireCompliance fails with an authorization error unless the caller
+// holds the compliance role. action describes the refused operation.
+func requireCompliance(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, action string) error {
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return err
+	}
+	if !cfg.isCompliance(caller) {
+		return newCodedError(codeUnauthorized, "%s may only be done by compliance", action)
+	}
+	return nil
+}
+
+// requireOwner fails with an authorization error unless the caller owns
+// acct. Accounts that predate ownership have no owner and can only be
+// debited by an admin.
+func requireOwner(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, acct *account) error {
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return err
+	}
+	if acct.Owner == "" {
+		if cfg.isAdmin(caller) {
+			return nil
+		}
+		return newCodedError(codeUnauthorized, "%s has no owner and may only be debited by an admin", acct.ID)
+	}
+	if acct.Owner != caller {
+		return newCodedError(codeUnauthorized, "Only the owner of %s may debit it", acct.ID)
+	}
+	return nil
+}
+
+// Returns the identity the chaincode sees for the caller, in the form used
+// for account owners and the admins option of Init.
+func (t *SimpleChaincode) whoami(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(caller), nil
+}
@@ -0,0 +1,580 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Composite key namespaces of rate schedules, keyed by account class, and
+// of the postings accrual makes, keyed by account and transaction.
+const (
+	scheduleObjectType = "schedule"
+	postingObjectType  = "posting"
+)
+
+// interestYear is the year a yearly rate is spread over.
+const interestYear = 365 * 24 * time.Hour
+
+// Kinds of posting.
+const (
+	postingInterest = "INTEREST"
+	postingFee      = "FEE"
+)
+
+// schedule is the interest rate and maintenance fee of one account class.
+// Interest is paid out of, and fees are paid into, the settlement account,
+// so accrual moves funds rather than issuing them.
+type schedule struct {
+	Class string `json:"class"`
+	// Rate is the yearly rate as a fraction, "0.05" for 5%. Interest is
+	// simple interest on the balance held since the last accrual.
+	Rate Amount `json:"rate"`
+	// Fee is charged once for every FeePeriod that has passed.
+	Fee        *Amount `json:"fee,omitempty"`
+	FeePeriod  string  `json:"feePeriod,omitempty"`
+	Settlement string  `json:"settlement"`
+	UpdatedBy  string  `json:"updatedBy"`
+	UpdatedTx  string  `json:"updatedTx"`
+}
+
+// scheduleSpec is the setSchedule argument.
+type scheduleSpec struct {
+	Rate       string `json:"rate"`
+	Fee        string `json:"fee"`
+	FeePeriod  string `json:"feePeriod"`
+	Settlement string `json:"settlement"`
+}
+
+// accrual puts an account in a class and records how far its interest and
+// fees have been accrued.
+type accrual struct {
+	Class        string `json:"class"`
+	InterestFrom string `json:"interestFrom"`
+	FeesFrom     string `json:"feesFrom"`
+	// BalanceTime sums balance × nanoseconds over the balances the account
+	// held from InterestFrom to BalanceFrom, so that interest follows the
+	// balance through the period. BalanceFrom is empty until the balance
+	// first changes.
+	BalanceTime *Amount `json:"balanceTime,omitempty"`
+	BalanceFrom string  `json:"balanceFrom,omitempty"`
+}
+
+// posting is one interest credit or fee debit made by accrual.
+type posting struct {
+	Account string `json:"account"`
+	Kind    string `json:"kind"`
+	Amount  Amount `json:"amount"`
+	// Counterparty is the settlement account on the other side.
+	Counterparty string `json:"counterparty"`
+	Class        string `json:"class"`
+	// From and To bound the period the posting covers.
+	From string `json:"from"`
+	To   string `json:"to"`
+	TxID string `json:"txid"`
+}
+
+// scheduleChangedEvent is emitted by setSchedule.
+type scheduleChangedEvent struct {
+	eventHeader
+	Schedule *schedule `json:"schedule"`
+}
+
+// accruedEvent is emitted by accrue, and by setClass when it closes the
+// account's old class. Postings is empty when nothing was due.
+type accruedEvent struct {
+	eventHeader
+	Account  string     `json:"account"`
+	Class    string     `json:"class"`
+	Postings []*posting `json:"postings"`
+}
+
+func scheduleKey(stub shim.ChaincodeStubInterface, class string) (string, error) {
+	return stub.CreateCompositeKey(scheduleObjectType, []string{class})
+}
+
+// getSchedule reads the schedule of class, or nil if it has none.
+func getSchedule(stub shim.ChaincodeStubInterface, class string) (*schedule, error) {
+	key, err := scheduleKey(stub, class)
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get schedule of %s", class)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	s := &schedule{}
+	if err := json.Unmarshal(value, s); err != nil {
+		return nil, fmt.Errorf("Corrupt schedule of %s: %s", class, err)
+	}
+	return s, nil
+}
+
+// loadSchedule is getSchedule for a class that must have a schedule.
+func loadSchedule(stub shim.ChaincodeStubInterface, class string) (*schedule, error) {
+	s, err := getSchedule(stub, class)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, newArgumentError(codeInvalidArgument, "class", "Class %s has no schedule", class)
+	}
+	return s, nil
+}
+
+// parseSchedule validates spec as the schedule of class.
+func parseSchedule(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, class string, spec *scheduleSpec) (*schedule, error) {
+	s := &schedule{Class: class, Rate: Amount{}, Settlement: spec.Settlement}
+	if spec.Rate != "" {
+		rate, err := parseAmount(spec.Rate)
+		if err != nil || rate.Sign() < 0 {
+			return nil, newArgumentError(codeInvalidArgument, "schedule", "Invalid schedule: rate must be a non-negative decimal")
+		}
+		s.Rate = rate
+	}
+	if spec.Fee != "" {
+		fee, err := parseTransferAmount(spec.Fee, cfg.Scale)
+		if err != nil {
+			return nil, withArgument(err, "schedule")
+		}
+		period, err := time.ParseDuration(spec.FeePeriod)
+		if err != nil || period <= 0 {
+			return nil, newArgumentError(codeInvalidArgument, "schedule", "Invalid schedule: a fee needs a positive feePeriod")
+		}
+		s.Fee = &fee
+		s.FeePeriod = spec.FeePeriod
+	} else if spec.FeePeriod != "" {
+		return nil, newArgumentError(codeInvalidArgument, "schedule", "Invalid schedule: feePeriod given without a fee")
+	}
+	if s.Rate.Sign() == 0 && s.Fee == nil {
+		return nil, newArgumentError(codeInvalidArgument, "schedule", "Invalid schedule: give a rate, a fee or both")
+	}
+
+	if spec.Settlement == "" {
+		return nil, newArgumentError(codeInvalidArgument, "schedule", "Invalid schedule: missing settlement account")
+	}
+	settle, err := loadLiveAccount(stub, spec.Settlement)
+	if err != nil {
+		return nil, withArgument(err, "schedule")
+	}
+	if settle.Private != nil {
+		return nil, newArgumentError(codeInvalidArgument, "schedule", "Invalid schedule: settlement account %s is private", settle.ID)
+	}
+	return s, nil
+}
+
+func parseAccrualTime(acct *account, value string) (time.Time, error) {
+	at, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Corrupt accrual state of %s: %s", acct.ID, err)
+	}
+	return at, nil
+}
+
+// balanceTime returns the balance × nanoseconds acct has held since its
+// interest was last posted, taking held as its balance since the last change.
+func balanceTime(acct *account, held Amount, now time.Time) (Amount, error) {
+	r := acct.Accrual
+	total := Amount{}
+	if r.BalanceTime != nil {
+		total = *r.BalanceTime
+	}
+	from := r.BalanceFrom
+	if from == "" {
+		from = r.InterestFrom
+	}
+	since, err := parseAccrualTime(acct, from)
+	if err != nil {
+		return Amount{}, err
+	}
+	if now.After(since) {
+		elapsed := big.NewInt(int64(now.Sub(since)))
+		total = total.Add(Amount{units: new(big.Int).Mul(held.int(), elapsed), scale: held.scale})
+	}
+	return total, nil
+}
+
+// noteBalanceChange adds the balance acct held until now to its balance
+// time. putAccount calls it before saving a new balance.
+func noteBalanceChange(stub shim.ChaincodeStubInterface, acct *account) error {
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	total, err := balanceTime(acct, acct.loaded, now)
+	if err != nil {
+		return err
+	}
+	acct.Accrual.BalanceTime = &total
+	acct.Accrual.BalanceFrom = now.UTC().Format(time.RFC3339Nano)
+	return nil
+}
+
+// pauseAccrual stops the balance time of acct as it is frozen, so that no
+// interest accrues while the freeze lasts. The balance cannot change until
+// the freeze is lifted.
+func pauseAccrual(stub shim.ChaincodeStubInterface, acct *account) error {
+	if acct.Accrual == nil {
+		return nil
+	}
+	if err := noteBalanceChange(stub, acct); err != nil {
+		return err
+	}
+	acct.Frozen.FrozenAt = acct.Accrual.BalanceFrom
+	return nil
+}
+
+// resumeAccrual restarts the balance time of acct as its freeze is lifted,
+// leaving out the time it was frozen.
+func resumeAccrual(stub shim.ChaincodeStubInterface, acct *account) error {
+	if acct.Accrual == nil || acct.Frozen.FrozenAt == "" {
+		return nil
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	acct.Accrual.BalanceFrom = now.UTC().Format(time.RFC3339Nano)
+	return nil
+}
+
+// accrueAccount brings acct's interest and fees up to now under the
+// schedule of its class. It writes the settlement account and the postings
+// but leaves acct, whose balance it updates, for the caller to save. Amounts
+// are computed exactly from transaction timestamps and rounded once, so
+// every endorser posts the same values. Nothing is posted while acct or the
+// settlement account is frozen. Interest does not accrue over the time acct
+// is frozen, but fees for that time, and interest owed while only the
+// settlement account is frozen, are posted once the freeze is lifted.
+func accrueAccount(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, acct *account, now time.Time) ([]*posting, error) {
+	s, err := loadSchedule(stub, acct.Accrual.Class)
+	if err != nil {
+		return nil, err
+	}
+	if s.Settlement == acct.ID {
+		return nil, newCodedError(codeInvalidState, "Account %s settles its own class %s", acct.ID, s.Class)
+	}
+	err = requireUnfrozen(acct)
+	if err != nil {
+		return nil, err
+	}
+	settle, err := loadLiveAccount(stub, s.Settlement)
+	if err != nil {
+		return nil, err
+	}
+	err = requireUnfrozen(settle)
+	if err != nil {
+		return nil, err
+	}
+
+	postings := []*posting{}
+	newPosting := func(kind string, amount Amount, from, to time.Time) {
+		postings = append(postings, &posting{
+			Account:      acct.ID,
+			Kind:         kind,
+			Amount:       amount,
+			Counterparty: settle.ID,
+			Class:        s.Class,
+			From:         from.UTC().Format(time.RFC3339Nano),
+			To:           to.UTC().Format(time.RFC3339Nano),
+			TxID:         stub.GetTxID(),
+		})
+	}
+
+	interest := Amount{}.quantize(cfg.Scale)
+	from, err := parseAccrualTime(acct, acct.Accrual.InterestFrom)
+	if err != nil {
+		return nil, err
+	}
+	if now.After(from) {
+		held, err := balanceTime(acct, acct.Balance, now)
+		if err != nil {
+			return nil, err
+		}
+		interest = held.mulFrac(s.Rate, big.NewInt(1), big.NewInt(int64(interestYear)), cfg.Scale)
+		// Interest too small to post keeps accruing from the same start.
+		if interest.Sign() > 0 {
+			acct.Accrual.InterestFrom = now.UTC().Format(time.RFC3339Nano)
+			acct.Accrual.BalanceTime = nil
+			acct.Accrual.BalanceFrom = ""
+			newPosting(postingInterest, interest, from, now)
+		}
+	}
+
+	fee := Amount{}.quantize(cfg.Scale)
+	if s.Fee != nil {
+		from, err := parseAccrualTime(acct, acct.Accrual.FeesFrom)
+		if err != nil {
+			return nil, err
+		}
+		period, err := time.ParseDuration(s.FeePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("Corrupt schedule of %s: %s", s.Class, err)
+		}
+		if n := int64(now.Sub(from) / period); n > 0 {
+			to := from.Add(time.Duration(n) * period)
+			acct.Accrual.FeesFrom = to.UTC().Format(time.RFC3339Nano)
+			fee = s.Fee.mulFrac(Amount{units: big.NewInt(n)}, big.NewInt(1), big.NewInt(1), cfg.Scale)
+			// A fee never overdraws the account or takes held funds;
+			// what it cannot cover is waived.
+			available, err := availableBalance(stub, acct, acct.Currency, acct.Balance.Add(interest))
+			if err != nil {
+				return nil, err
+			}
+			if available.Sign() < 0 {
+				available = Amount{}.quantize(cfg.Scale)
+			}
+			if fee.Cmp(available) > 0 {
+				fee = available
+			}
+			if fee.Sign() > 0 {
+				newPosting(postingFee, fee, from, to)
+			}
+		}
+	}
+
+	if len(postings) == 0 {
+		return postings, nil
+	}
+	settled := settle.Balance.Sub(interest).Add(fee)
+	if settled.Sign() < 0 {
+		return nil, newCodedError(codeInsufficientFunds, "Settlement account %s holds %s, cannot pay %s interest to %s", settle.ID, settle.Balance, interest, acct.ID)
+	}
+	acct.Balance = acct.Balance.Add(interest).Sub(fee)
+	settle.Balance = settled
+	if err := putAccount(stub, settle); err != nil {
+		return nil, err
+	}
+
+	for _, p := range postings {
+		key, err := stub.CreateCompositeKey(postingObjectType, []string{p.Account, p.TxID, p.Kind})
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := stub.PutState(key, value); err != nil {
+			return nil, err
+		}
+	}
+	return postings, nil
+}
+
+// Sets the interest rate and maintenance fee of an account class from a JSON
+// document such as
+// {"rate":"0.05","fee":"1","feePeriod":"720h","settlement":"BANK"}.
+// A changed schedule applies to each account from its next accrual. Only an
+// admin may set it.
+func (t *SimpleChaincode) setSchedule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	class := args[0]
+	var spec scheduleSpec
+	if err := decodeStrict(args[1], &spec); err != nil {
+		return nil, newArgumentError(codeInvalidArgument, "schedule", "Invalid schedule: %s", err)
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireAdmin(stub, cfg, "Setting a schedule")
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := parseSchedule(stub, cfg, class, &spec)
+	if err != nil {
+		return nil, err
+	}
+	s.UpdatedBy = caller
+	s.UpdatedTx = stub.GetTxID()
+
+	key, err := scheduleKey(stub, class)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventScheduleChanged, &scheduleChangedEvent{eventHeader: newEventHeader(stub), Schedule: s})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Puts an account in a class. Interest and fees run from this transaction;
+// what the account had accrued in its old class is posted first. Only an
+// admin may change it.
+func (t *SimpleChaincode) setClass(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	A, class := args[0], args[1]
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireAdmin(stub, cfg, "Changing an account class")
+	if err != nil {
+		return nil, err
+	}
+	acct, err := loadLiveAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	if acct.Private != nil {
+		return nil, newArgumentError(codeInvalidArgument, "entity", "Private account %s cannot accrue interest", A)
+	}
+	err = requireUnfrozen(acct)
+	if err != nil {
+		return nil, err
+	}
+	s, err := loadSchedule(stub, class)
+	if err != nil {
+		return nil, err
+	}
+	if s.Settlement == A {
+		return nil, newArgumentError(codeInvalidArgument, "class", "Account %s settles class %s", A, class)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &accruedEvent{eventHeader: newEventHeader(stub), Account: A, Class: class, Postings: []*posting{}}
+	if acct.Accrual != nil {
+		event.Postings, err = accrueAccount(stub, cfg, acct, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	start := now.UTC().Format(time.RFC3339Nano)
+	acct.Accrual = &accrual{Class: class, InterestFrom: start, FeesFrom: start}
+
+	err = putAccount(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventAccrued, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Posts the interest and fees an account has accrued since its last accrual
+// and returns the postings. Only an admin may run it, so that accounts are
+// accrued on the schedule the operator chooses.
+func (t *SimpleChaincode) accrue(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	A := args[0]
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireAdmin(stub, cfg, "Accruing interest")
+	if err != nil {
+		return nil, err
+	}
+	acct, err := loadLiveAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	if acct.Accrual == nil {
+		return nil, newArgumentError(codeInvalidState, "entity", "Account %s has no class", A)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	postings, err := accrueAccount(stub, cfg, acct, now)
+	if err != nil {
+		return nil, err
+	}
+	err = putAccount(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventAccrued, &accruedEvent{
+		eventHeader: newEventHeader(stub),
+		Account:     A,
+		Class:       acct.Accrual.Class,
+		Postings:    postings,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(postings)
+}
+
+// Returns the schedule of an account class.
+func (t *SimpleChaincode) scheduleQuery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	s, err := loadSchedule(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(s)
+}
+
+// Returns every posting made to an account.
+func (t *SimpleChaincode) postingsQuery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	A := args[0]
+
+	iter, err := stub.GetStateByPartialCompositeKey(postingObjectType, []string{A})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get postings of %s: %s", A, err)
+	}
+	defer iter.Close()
+
+	postings := []*posting{}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get postings of %s: %s", A, err)
+		}
+		p := &posting{}
+		if err := json.Unmarshal(kv.Value, p); err != nil {
+			return nil, fmt.Errorf("Corrupt posting of %s: %s", A, err)
+		}
+		postings = append(postings, p)
+	}
+	return json.Marshal(postings)
+}
@@ -0,0 +1,227 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newInterestFixture puts A in class SAVINGS, which pays 10% a year and
+// charges 1 every 30 days, settled against B. Amounts have two decimals.
+func newInterestFixture(t *testing.T) *fixture {
+	f := newFixture(t, "A", "100", "B", "200", `{"scale":2}`)
+	checkOK(t, f.invoke(f.admin, "setSchedule", "SAVINGS", `{"rate":"0.1","fee":"1","feePeriod":"720h","settlement":"B"}`))
+	f.checkEvent(t, eventScheduleChanged)
+	checkOK(t, f.invoke(f.admin, "setClass", "A", "SAVINGS"))
+	return f
+}
+
+// accrue runs accrue on id and decodes the postings it returns.
+func (f *fixture) accrue(t *testing.T, id string) []*posting {
+	t.Helper()
+	res := f.invoke(f.admin, "accrue", id)
+	checkOK(t, res)
+	var postings []*posting
+	if err := json.Unmarshal(res.Payload, &postings); err != nil {
+		t.Fatal(err)
+	}
+	return postings
+}
+
+func TestAccrue(t *testing.T) {
+	f := newInterestFixture(t)
+
+	// 73 days at 10% on 100 is exactly 2; two fee periods have passed.
+	f.now = f.now.Add(73 * 24 * time.Hour)
+	postings := f.accrue(t, "A")
+	if len(postings) != 2 {
+		t.Fatalf("expected an interest and a fee posting, got %d", len(postings))
+	}
+	if p := postings[0]; p.Kind != postingInterest || p.Amount.String() != "2.00" || p.Counterparty != "B" {
+		t.Fatalf("unexpected interest posting %+v", p)
+	}
+	if p := postings[1]; p.Kind != postingFee || p.Amount.String() != "2.00" || p.To != "2020-03-01T12:00:00Z" {
+		t.Fatalf("unexpected fee posting %+v", p)
+	}
+	f.checkEvent(t, eventAccrued)
+	f.checkBalance(t, "A", "100.00")
+	f.checkBalance(t, "B", "200.00")
+
+	// Accruing again at the same time posts nothing; the fee period that
+	// has started carries over.
+	if postings := f.accrue(t, "A"); len(postings) != 0 {
+		t.Fatalf("expected no postings, got %d", len(postings))
+	}
+	f.now = f.now.Add(17 * 24 * time.Hour)
+	postings = f.accrue(t, "A")
+	if len(postings) != 2 || postings[1].Amount.String() != "1.00" {
+		t.Fatalf("expected a third fee, got %+v", postings)
+	}
+	// 17 days at 10% on 100 is 0.4657..., rounded to 0.47.
+	if postings[0].Amount.String() != "0.47" {
+		t.Fatalf("unexpected interest %s", postings[0].Amount)
+	}
+	f.checkBalance(t, "A", "99.47")
+	f.checkBalance(t, "B", "200.53")
+	f.checkSupply(t, defaultCurrency, "300.00")
+
+	res := f.invoke(f.alice, "postings", "A")
+	checkOK(t, res)
+	var recorded []*posting
+	if err := json.Unmarshal(res.Payload, &recorded); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 4 {
+		t.Fatalf("expected 4 recorded postings, got %s", res.Payload)
+	}
+}
+
+func TestInterestFollowsBalance(t *testing.T) {
+	f := newInterestFixture(t)
+
+	// 100 for 36.5 days and 50 for 36.5 days at 10% is 1 + 0.5.
+	f.now = f.now.Add(36*24*time.Hour + 12*time.Hour)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "50"))
+	f.now = f.now.Add(36*24*time.Hour + 12*time.Hour)
+	postings := f.accrue(t, "A")
+	if len(postings) != 2 || postings[0].Kind != postingInterest || postings[0].Amount.String() != "1.50" {
+		t.Fatalf("expected 1.50 interest, got %+v", postings)
+	}
+	f.checkBalance(t, "A", "49.50")
+}
+
+func TestSmallInterestCarriesOver(t *testing.T) {
+	f := newInterestFixture(t)
+	checkOK(t, f.invoke(f.admin, "setSchedule", "TINY", `{"rate":"0.01","settlement":"B"}`))
+	checkOK(t, f.invoke(f.admin, "setClass", "A", "TINY"))
+
+	// A day at 1% on 100 is 0.0027, too small to post, but two days are
+	// worth 0.01.
+	f.now = f.now.Add(24 * time.Hour)
+	if postings := f.accrue(t, "A"); len(postings) != 0 {
+		t.Fatalf("expected no postings, got %+v", postings)
+	}
+	f.now = f.now.Add(24 * time.Hour)
+	postings := f.accrue(t, "A")
+	if len(postings) != 1 || postings[0].Amount.String() != "0.01" || postings[0].From != testNow.Format(time.RFC3339Nano) {
+		t.Fatalf("expected 0.01 over two days, got %+v", postings)
+	}
+}
+
+func TestAccrueIsReproducible(t *testing.T) {
+	var results []string
+	for i := 0; i < 2; i++ {
+		f := newInterestFixture(t)
+		f.now = f.now.Add(100*24*time.Hour + 7*time.Second)
+		res := f.invoke(f.admin, "accrue", "A")
+		checkOK(t, res)
+		results = append(results, string(res.Payload))
+	}
+	if results[0] != results[1] {
+		t.Fatalf("accrual differs between runs:\n%s\n%s", results[0], results[1])
+	}
+}
+
+func TestFeeDoesNotOverdraw(t *testing.T) {
+	f := newInterestFixture(t)
+	checkOK(t, f.invoke(f.admin, "setSchedule", "FEES", `{"fee":"60","feePeriod":"24h","settlement":"B"}`))
+	checkOK(t, f.invoke(f.admin, "setClass", "A", "FEES"))
+	f.now = f.now.Add(48 * time.Hour)
+	postings := f.accrue(t, "A")
+	if len(postings) != 1 || postings[0].Amount.String() != "100.00" {
+		t.Fatalf("expected the fee to be capped at the balance, got %+v", postings)
+	}
+	f.checkBalance(t, "A", "0.00")
+}
+
+func TestAccrualRespectsCompliance(t *testing.T) {
+	f := newInterestFixture(t)
+	checkOK(t, f.invoke(f.admin, "setSchedule", "FEES", `{"fee":"60","feePeriod":"24h","settlement":"B"}`))
+	checkOK(t, f.invoke(f.admin, "setClass", "A", "FEES"))
+	f.now = f.now.Add(48 * time.Hour)
+
+	checkOK(t, f.invoke(f.admin, "freeze", "B", "SANCTIONS"))
+	checkFailed(t, f.invoke(f.admin, "accrue", "A"), shim.ERRORTHRESHOLD, codeAccountFrozen)
+	checkOK(t, f.invoke(f.admin, "unfreeze", "B", "CLEARED"))
+	checkOK(t, f.invoke(f.admin, "freeze", "A", "SANCTIONS"))
+	checkFailed(t, f.invoke(f.admin, "accrue", "A"), shim.ERRORTHRESHOLD, codeAccountFrozen)
+	checkFailed(t, f.invoke(f.admin, "setClass", "A", "SAVINGS"), shim.ERRORTHRESHOLD, codeAccountFrozen)
+	checkOK(t, f.invoke(f.admin, "unfreeze", "A", "CLEARED"))
+
+	// Fees never take held funds.
+	checkOK(t, f.invoke(f.admin, "hold", "A", "h1", "30", "COURT_ORDER"))
+	postings := f.accrue(t, "A")
+	if len(postings) != 1 || postings[0].Amount.String() != "70.00" {
+		t.Fatalf("expected the fee to be capped at the available balance, got %+v", postings)
+	}
+	f.checkBalance(t, "A", "30.00")
+}
+
+func TestNoInterestWhileFrozen(t *testing.T) {
+	f := newInterestFixture(t)
+	checkOK(t, f.invoke(f.admin, "setSchedule", "PLAIN", `{"rate":"0.1","settlement":"B"}`))
+	checkOK(t, f.invoke(f.admin, "setClass", "A", "PLAIN"))
+
+	// 36.5 days before the freeze and 36.5 after it at 10% on 100 is 2;
+	// the year frozen in between earns nothing.
+	f.now = f.now.Add(36*24*time.Hour + 12*time.Hour)
+	checkOK(t, f.invoke(f.admin, "freeze", "A", "SANCTIONS"))
+	f.now = f.now.Add(365 * 24 * time.Hour)
+	checkOK(t, f.invoke(f.admin, "unfreeze", "A", "CLEARED"))
+	f.now = f.now.Add(36*24*time.Hour + 12*time.Hour)
+	postings := f.accrue(t, "A")
+	if len(postings) != 1 || postings[0].Amount.String() != "2.00" {
+		t.Fatalf("expected 2.00 interest, got %+v", postings)
+	}
+}
+
+func TestSetClassPostsOldClass(t *testing.T) {
+	f := newInterestFixture(t)
+	checkOK(t, f.invoke(f.admin, "setSchedule", "BASIC", `{"rate":"0.01","settlement":"B"}`))
+	f.now = f.now.Add(73 * 24 * time.Hour)
+	checkOK(t, f.invoke(f.admin, "setClass", "A", "BASIC"))
+	f.checkBalance(t, "A", "100.00")
+	f.checkBalance(t, "B", "200.00")
+
+	f.now = f.now.Add(365 * 24 * time.Hour)
+	postings := f.accrue(t, "A")
+	if len(postings) != 1 || postings[0].Class != "BASIC" || postings[0].Amount.String() != "1.00" {
+		t.Fatalf("expected a year of BASIC interest, got %+v", postings)
+	}
+}
+
+func TestAccrualErrors(t *testing.T) {
+	f := newInterestFixture(t)
+	checkFailed(t, f.invoke(f.alice, "accrue", "A"), shim.ERRORTHRESHOLD, codeUnauthorized)
+	checkFailed(t, f.invoke(f.admin, "accrue", "B"), shim.ERRORTHRESHOLD, codeInvalidState)
+	checkFailed(t, f.invoke(f.admin, "setClass", "B", "SAVINGS"), shim.ERRORTHRESHOLD, codeInvalidArgument)
+	checkFailed(t, f.invoke(f.admin, "setClass", "A", "GOLD"), shim.ERRORTHRESHOLD, "has no schedule")
+	checkFailed(t, f.invoke(f.alice, "setSchedule", "X", `{"rate":"0.1","settlement":"B"}`), shim.ERRORTHRESHOLD, codeUnauthorized)
+	checkFailed(t, f.invoke(f.admin, "setSchedule", "X", `{"rate":"-0.1","settlement":"B"}`), shim.ERRORTHRESHOLD, "rate must be")
+	checkFailed(t, f.invoke(f.admin, "setSchedule", "X", `{"fee":"1","settlement":"B"}`), shim.ERRORTHRESHOLD, "positive feePeriod")
+	checkFailed(t, f.invoke(f.admin, "setSchedule", "X", `{"settlement":"B"}`), shim.ERRORTHRESHOLD, "give a rate")
+	checkFailed(t, f.invoke(f.admin, "setSchedule", "X", `{"rate":"0.1","settlement":"Z"}`), shim.ERRORTHRESHOLD, codeEntityNotFound)
+
+	// Interest the settlement account cannot pay is refused.
+	checkOK(t, f.invoke(f.admin, "transfer", "B", "A", "200"))
+	f.now = f.now.Add(365 * 24 * time.Hour)
+	checkFailed(t, f.invoke(f.admin, "accrue", "A"), shim.ERRORTHRESHOLD, codeInsufficientFunds)
+}
@@ -0,0 +1,303 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Composite key namespaces of journal entries, keyed by transaction, and of
+// the index listing the entries that touch each account.
+const (
+	journalObjectType      = "journal"
+	journalIndexObjectType = "journal~account~txid"
+)
+
+// Kinds of holder a journal leg can name. The issuance holder of an asset
+// balances the legs of a transaction that changes its supply.
+const (
+	holderAccount  = "account"
+	holderEscrow   = "escrow"
+	holderHTLC     = "htlc"
+	holderApproval = "approval"
+	holderIssuance = "issuance"
+)
+
+// journalTimeFormat is RFC 3339 with fixed-width nanoseconds, so that UTC
+// timestamps sort as strings.
+const journalTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Sides of a journal leg, from the holder's point of view: a debit takes
+// funds out, a credit puts them in.
+const (
+	legDebit  = "DEBIT"
+	legCredit = "CREDIT"
+)
+
+// holder names where a tracked balance is held.
+type holder struct {
+	kind string
+	id   string
+	// private holders are accounts whose amounts stay off the public
+	// journal.
+	private bool
+}
+
+func accountHolder(acct *account) holder {
+	return holder{kind: holderAccount, id: acct.ID, private: acct.Private != nil}
+}
+
+// legKey identifies the running total of one holder in one asset.
+type legKey struct {
+	kind, id, asset string
+}
+
+// journalEntry records every balance change of one transaction. Entries are
+// written once, under the transaction ID, and never changed. For each asset
+// the debits equal the credits.
+type journalEntry struct {
+	TxID      string       `json:"txid"`
+	Timestamp string       `json:"timestamp"`
+	Memo      string       `json:"memo"`
+	Legs      []journalLeg `json:"legs"`
+}
+
+// journalLeg is the net change of one holder in one asset. The amount of a
+// private account is left out.
+type journalLeg struct {
+	Kind    string  `json:"kind"`
+	Holder  string  `json:"holder"`
+	Asset   string  `json:"asset"`
+	Side    string  `json:"side"`
+	Amount  *Amount `json:"amount,omitempty"`
+	Private bool    `json:"private,omitempty"`
+}
+
+// journalAudit is the response of the journal query.
+type journalAudit struct {
+	Account string          `json:"account"`
+	Asset   string          `json:"asset"`
+	Entries []*journalEntry `json:"entries"`
+	// Reconstructed is the sum of the account's legs. Consistent reports
+	// whether it equals the balance on the ledger.
+	Reconstructed Amount `json:"reconstructed"`
+	Balance       Amount `json:"balance"`
+	Consistent    bool   `json:"consistent"`
+}
+
+// note adds delta to what h holds of symbol in this transaction. Accounts
+// are noted even when their balance is unchanged, so that the entry is
+// listed under every account the transaction wrote.
+func (s *supplyStub) note(h holder, symbol string, delta Amount) {
+	key := legKey{kind: h.kind, id: h.id, asset: symbol}
+	s.changes[key] = s.changes[key].Add(delta)
+	if h.private {
+		s.private[key] = true
+	}
+}
+
+// hideAmount keeps the amount h moves in symbol off the journal, as for a
+// private account. A public account paying or paid by a private one would
+// otherwise reveal the private amount.
+func hideAmount(stub shim.ChaincodeStubInterface, h holder, symbol string) {
+	if s, ok := stub.(*supplyStub); ok {
+		s.private[legKey{kind: h.kind, id: h.id, asset: symbol}] = true
+	}
+}
+
+// writeJournal saves the transaction's journal entry and indexes it under
+// every account it touched. Call it after check, so that the legs balance.
+func (s *supplyStub) writeJournal() error {
+	if len(s.changes) == 0 {
+		return nil
+	}
+	keys := make([]legKey, 0, len(s.changes))
+	for key := range s.changes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.id != b.id {
+			return a.id < b.id
+		}
+		return a.asset < b.asset
+	})
+
+	now, err := txTime(s)
+	if err != nil {
+		return err
+	}
+	entry := &journalEntry{
+		TxID:      s.GetTxID(),
+		Timestamp: now.UTC().Format(journalTimeFormat),
+		Memo:      s.memo,
+		Legs:      []journalLeg{},
+	}
+	var accounts []string
+	for _, key := range keys {
+		if key.kind == holderAccount && (len(accounts) == 0 || accounts[len(accounts)-1] != key.id) {
+			accounts = append(accounts, key.id)
+		}
+		change := s.changes[key]
+		if change.Sign() == 0 {
+			continue
+		}
+		leg := journalLeg{Kind: key.kind, Holder: key.id, Asset: key.asset, Side: legCredit}
+		if change.Sign() < 0 {
+			leg.Side = legDebit
+			change = Amount{}.Sub(change)
+		}
+		if s.private[key] {
+			leg.Private = true
+		} else {
+			leg.Amount = &change
+		}
+		entry.Legs = append(entry.Legs, leg)
+	}
+
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	key, err := s.CreateCompositeKey(journalObjectType, []string{entry.TxID})
+	if err != nil {
+		return err
+	}
+	if err := s.PutState(key, value); err != nil {
+		return err
+	}
+	for _, id := range accounts {
+		key, err := s.CreateCompositeKey(journalIndexObjectType, []string{id, entry.TxID})
+		if err != nil {
+			return err
+		}
+		if err := s.PutState(key, indexValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getJournalEntry reads the journal entry of txID.
+func getJournalEntry(stub shim.ChaincodeStubInterface, txID string) (*journalEntry, error) {
+	key, err := stub.CreateCompositeKey(journalObjectType, []string{txID})
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get journal entry %s", txID)
+	}
+	if value == nil {
+		return nil, fmt.Errorf("Journal index refers to missing entry %s", txID)
+	}
+	entry := &journalEntry{}
+	if err := json.Unmarshal(value, entry); err != nil {
+		return nil, fmt.Errorf("Corrupt journal entry %s: %s", txID, err)
+	}
+	return entry, nil
+}
+
+// Returns the journal entries of an account, oldest first, and checks that
+// its legs in the account currency, or in the named asset, add up to the
+// balance on the ledger. Balances that predate the journal, and transfers
+// with private accounts, whose amounts are left out, show up as an
+// inconsistency.
+func (t *SimpleChaincode) journal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	A := args[0]
+
+	acct, err := getPublicAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, newArgumentError(codeEntityNotFound, "entity", "Entity %s not found", A)
+	}
+	if acct.Private != nil {
+		return nil, newArgumentError(codeInvalidArgument, "entity", "The balance of private account %s is not journaled", A)
+	}
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	symbol := acct.Currency
+	if len(args) > 1 {
+		symbol = args[1]
+	}
+	a, err := getAsset(stub, cfg, symbol)
+	if err != nil {
+		return nil, withArgument(err, "asset")
+	}
+	balance, err := balanceOf(stub, acct, a)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := stub.GetStateByPartialCompositeKey(journalIndexObjectType, []string{A})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get journal of %s: %s", A, err)
+	}
+	defer iter.Close()
+
+	audit := &journalAudit{Account: A, Asset: a.Symbol, Entries: []*journalEntry{}, Balance: balance}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get journal of %s: %s", A, err)
+		}
+		_, keyParts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) != 2 {
+			return nil, fmt.Errorf("Corrupt journal index entry of %s", A)
+		}
+		entry, err := getJournalEntry(stub, keyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		audit.Entries = append(audit.Entries, entry)
+	}
+	sort.SliceStable(audit.Entries, func(i, j int) bool {
+		return audit.Entries[i].Timestamp < audit.Entries[j].Timestamp
+	})
+
+	reconstructed := Amount{}.quantize(a.Decimals)
+	for _, entry := range audit.Entries {
+		for _, leg := range entry.Legs {
+			if leg.Kind != holderAccount || leg.Holder != A || leg.Asset != a.Symbol || leg.Amount == nil {
+				continue
+			}
+			if leg.Side == legDebit {
+				reconstructed = reconstructed.Sub(*leg.Amount)
+			} else {
+				reconstructed = reconstructed.Add(*leg.Amount)
+			}
+		}
+	}
+	audit.Reconstructed = reconstructed
+	audit.Consistent = reconstructed.Cmp(balance) == 0
+
+	return json.Marshal(audit)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// audit runs the journal query and checks that it is consistent.
+func (f *fixture) audit(t *testing.T, args ...string) *journalAudit {
+	t.Helper()
+	res := f.invoke(f.alice, append([]string{"journal"}, args...)...)
+	checkOK(t, res)
+	audit := &journalAudit{}
+	if err := json.Unmarshal(res.Payload, audit); err != nil {
+		t.Fatal(err)
+	}
+	if !audit.Consistent {
+		t.Fatalf("journal of %s does not add up: %s", args[0], res.Payload)
+	}
+	return audit
+}
+
+// checkBalanced fails unless the debits and credits of entry are equal in
+// every asset.
+func checkBalanced(t *testing.T, entry *journalEntry) {
+	t.Helper()
+	net := make(map[string]Amount)
+	for _, leg := range entry.Legs {
+		if leg.Amount == nil {
+			t.Fatalf("leg without an amount in %s", entry.TxID)
+		}
+		if leg.Side == legDebit {
+			net[leg.Asset] = net[leg.Asset].Sub(*leg.Amount)
+		} else {
+			net[leg.Asset] = net[leg.Asset].Add(*leg.Amount)
+		}
+	}
+	for asset, n := range net {
+		if n.Sign() != 0 {
+			t.Fatalf("%s legs of %s (%s) do not balance", asset, entry.TxID, entry.Memo)
+		}
+	}
+}
+
+func TestJournal(t *testing.T) {
+	f := newAssetFixture(t)
+	f.now = f.now.Add(time.Hour)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "10"))
+	f.now = f.now.Add(time.Minute)
+	checkOK(t, f.invoke(f.admin, "escrowOpen", "e1", "A", "B", "arb", "5", f.now.Add(time.Hour).Format(time.RFC3339)))
+	f.now = f.now.Add(time.Minute)
+	checkOK(t, f.invoke(f.admin, "burn", "A", "1.5", "GOLD"))
+
+	a := f.audit(t, "A")
+	memos := []string{}
+	for _, entry := range a.Entries {
+		checkBalanced(t, entry)
+		memos = append(memos, entry.Memo)
+	}
+	if fmt.Sprint(memos) != "[init mint transfer escrowOpen burn]" {
+		t.Fatalf("unexpected journal of A: %v", memos)
+	}
+	if a.Balance.String() != "85" {
+		t.Fatalf("expected A to hold 85, got %s", a.Balance)
+	}
+
+	gold := f.audit(t, "A", "GOLD")
+	if gold.Balance.String() != "8.50" {
+		t.Fatalf("expected A to hold 8.50 GOLD, got %s", gold.Balance)
+	}
+	last := gold.Entries[len(gold.Entries)-1]
+	if last.Memo != "burn" || len(last.Legs) != 2 || last.Legs[0].Kind != holderAccount || last.Legs[0].Side != legDebit || last.Legs[1].Kind != holderIssuance || last.Legs[1].Side != legCredit {
+		t.Fatalf("unexpected burn entry %+v", last)
+	}
+	f.audit(t, "B")
+
+	checkOK(t, f.invoke(f.admin, "delete", "B"))
+	b := f.audit(t, "B")
+	if last := b.Entries[len(b.Entries)-1]; last.Memo != "delete" || len(last.Legs) != 0 {
+		t.Fatalf("expected a delete entry without legs, got %+v", last)
+	}
+}
+
+func TestJournalIsWrittenOnce(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "10"))
+	key, _ := f.CreateCompositeKey(journalObjectType, []string{fmt.Sprintf("tx%d", f.seq)})
+	entry := &journalEntry{}
+	if err := json.Unmarshal(f.State[key], entry); err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Legs) != 2 || entry.Legs[0].Holder != "A" || entry.Legs[0].Side != legDebit || entry.Legs[1].Holder != "B" || entry.Legs[1].Side != legCredit {
+		t.Fatalf("unexpected transfer entry %+v", entry)
+	}
+
+	// Failed transactions write nothing, read-only ones are not journaled.
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1000"), shim.ERRORTHRESHOLD, codeInsufficientFunds)
+	checkOK(t, f.invoke(f.alice, "query", "A"))
+	if n := len(f.audit(t, "A").Entries); n != 2 {
+		t.Fatalf("expected 2 entries for A, got %d", n)
+	}
+}
+
+func TestJournalLeavesOutPrivateAmounts(t *testing.T) {
+	f := newPrivateFixture(t)
+	checkOK(t, f.payPrivate("A", "P", "5"))
+	key, _ := f.CreateCompositeKey(journalObjectType, []string{fmt.Sprintf("tx%d", f.seq)})
+	entry := &journalEntry{}
+	if err := json.Unmarshal(f.State[key], entry); err != nil {
+		t.Fatal(err)
+	}
+	for _, leg := range entry.Legs {
+		if !leg.Private || leg.Amount != nil {
+			t.Fatalf("journal reveals the amount paid to P: %s", f.State[key])
+		}
+	}
+	checkFailed(t, f.invoke(f.alice, "journal", "P"), shim.ERRORTHRESHOLD, codeInvalidArgument)
+}
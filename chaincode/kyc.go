@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const (
+	// defaultKYCFunction is the registry function called when the options
+	// name none.
+	defaultKYCFunction = "status"
+	// kycApproved is the only registry status that lets an entity pay or be
+	// paid.
+	kycApproved = "APPROVED"
+)
+
+// kycConfig names the registry chaincode that vets the parties of every
+// payment. It is set through the "kyc" Init option.
+type kycConfig struct {
+	Chaincode string `json:"chaincode"`
+	// Channel is left empty when the registry is on this chaincode's
+	// channel.
+	Channel  string `json:"channel,omitempty"`
+	Function string `json:"function,omitempty"`
+}
+
+// kycStatus is the response the registry returns for
+// function(entity).
+type kycStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// function returns the registry function to call.
+func (k *kycConfig) function() string {
+	if k.Function == "" {
+		return defaultKYCFunction
+	}
+	return k.Function
+}
+
+// requireKYC asks the registry named in cfg about each of ids and fails
+// unless all of them are approved. Deployments without a registry skip the
+// check.
+func requireKYC(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, ids ...string) error {
+	if cfg.KYC == nil {
+		return nil
+	}
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		status, err := queryKYC(stub, cfg.KYC, id)
+		if err != nil {
+			return err
+		}
+		if status != kycApproved {
+			return newCodedError(codeKYCNotApproved, "Entity %s has KYC status %q", id, status)
+		}
+	}
+	return nil
+}
+
+// queryKYC calls the registry and validates its answer for id. The call
+// runs inside the current transaction, so the registry's reads are part of
+// its read set.
+func queryKYC(stub shim.ChaincodeStubInterface, k *kycConfig, id string) (string, error) {
+	args := [][]byte{[]byte(k.function()), []byte(id)}
+	res := stub.InvokeChaincode(k.Chaincode, args, k.Channel)
+	if res.Status != shim.OK {
+		return "", newCodedError(codeKYCUnavailable, "KYC registry %s failed for %s with status %d: %s", k.Chaincode, id, res.Status, res.Message)
+	}
+
+	var status kycStatus
+	if err := json.Unmarshal(res.Payload, &status); err != nil {
+		return "", newCodedError(codeKYCUnavailable, "KYC registry %s returned an invalid response for %s: %s", k.Chaincode, id, err)
+	}
+	if status.ID != id || status.Status == "" {
+		return "", newCodedError(codeKYCUnavailable, "KYC registry %s returned an invalid response for %s", k.Chaincode, id)
+	}
+	return status.Status, nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// kycRegistry is a stand-in for the registry chaincode. It answers
+// status(id) from a fixed table; reply, when set, overrides the answer.
+type kycRegistry struct {
+	statuses map[string]string
+	reply    *pb.Response
+}
+
+func (r *kycRegistry) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (r *kycRegistry) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	if r.reply != nil {
+		return *r.reply
+	}
+	function, args := stub.GetFunctionAndParameters()
+	if function != defaultKYCFunction || len(args) != 1 {
+		return shim.Error("unexpected call")
+	}
+	status, ok := r.statuses[args[0]]
+	if !ok {
+		return shim.Error("unknown entity")
+	}
+	payload, _ := json.Marshal(kycStatus{ID: args[0], Status: status})
+	return shim.Success(payload)
+}
+
+// newKYCFixture deploys the chaincode with a registry on channel ch1 that
+// approves A and B and has suspended C.
+func newKYCFixture(t *testing.T) (*fixture, *kycRegistry) {
+	registry := &kycRegistry{statuses: map[string]string{"A": kycApproved, "B": kycApproved, "C": "SUSPENDED"}}
+	f := newFixture(t, "A", "100", "B", "200", `{"kyc":{"chaincode":"kyc","channel":"ch1"}}`)
+	f.Invokables["kyc/ch1"] = shim.NewMockStub("kyc", registry)
+	checkOK(t, f.invoke(f.admin, "create", "C", "10"))
+	return f, registry
+}
+
+func TestKYCCheck(t *testing.T) {
+	f, _ := newKYCFixture(t)
+	later := f.now.Add(time.Hour).Format(time.RFC3339)
+
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "10"))
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "C", "1"), shim.ERRORTHRESHOLD, codeKYCNotApproved)
+	checkFailed(t, f.invoke(f.admin, "transfer", "C", "A", "1"), shim.ERRORTHRESHOLD, codeKYCNotApproved)
+	checkFailed(t, f.invoke(f.admin, "batchTransfer", "A", `[{"to":"B","amount":"1"},{"to":"C","amount":"1"}]`), shim.ERRORTHRESHOLD, codeKYCNotApproved)
+	checkFailed(t, f.invoke(f.admin, "escrowOpen", "e1", "A", "C", "arb", "1", later), shim.ERRORTHRESHOLD, codeKYCNotApproved)
+	checkFailed(t, f.invoke(f.admin, "htlcLock", "h1", "A", "C", "1", hashOf(t, testPreimage), later), shim.ERRORTHRESHOLD, codeKYCNotApproved)
+
+	checkOK(t, f.invoke(f.admin, "create", "D"))
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "D", "1"), shim.ERROR, codeKYCUnavailable)
+
+	f.checkBalance(t, "A", "90")
+	f.checkBalance(t, "B", "210")
+	f.checkBalance(t, "C", "10")
+}
+
+func TestKYCRegistryResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply pb.Response
+	}{
+		{name: "not JSON", reply: shim.Success([]byte("yes"))},
+		{name: "other entity", reply: shim.Success([]byte(`{"id":"Z","status":"APPROVED"}`))},
+		{name: "no status", reply: shim.Success([]byte(`{"id":"A"}`))},
+		{name: "registry error", reply: shim.Error("down")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, registry := newKYCFixture(t)
+			registry.reply = &test.reply
+			checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1"), shim.ERROR, codeKYCUnavailable)
+			f.checkBalance(t, "A", "100")
+		})
+	}
+}
+
+func TestKYCOptions(t *testing.T) {
+	s := newTestStub()
+	admin := newIdentity(t, "Org1MSP", "admin")
+	checkFailed(t, s.init(admin, "A", "100", "B", "200", `{"kyc":{"channel":"ch1"}}`), shim.ERRORTHRESHOLD, "kyc must name a chaincode")
+}
@@ -0,0 +1,355 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// testStub runs SimpleChaincode against an in-memory shim.MockStub. Unlike
+// MockInvoke it lets a test pick the submitting identity, keeps the last
+// chaincode event, can make chosen ledger calls fail, and answers the paged
+// range and history queries MockStub leaves unimplemented. Like a peer, it
+// commits a transaction's writes only if the transaction succeeds, and the
+// transaction itself reads the state as it was before it started.
+type testStub struct {
+	*shim.MockStub
+
+	cc     *SimpleChaincode
+	args   [][]byte
+	faults map[fault]error
+	seq    int
+
+	// lastEvent is the chaincode event set by the latest invocation.
+	lastEvent *pb.ChaincodeEvent
+
+	// now, when set, is used as the transaction timestamp instead of the
+	// wall clock.
+	now time.Time
+	// transient is the transient map of the next transaction only.
+	transient map[string][]byte
+	// history holds every write to each key, oldest first.
+	history map[string][]*queryresult.KeyModification
+	// writes buffers the writes of the running transaction until it ends.
+	writes []write
+}
+
+// write is one buffered PutState, DelState or PutPrivateData. An empty
+// value deletes a public key.
+type write struct {
+	collection string
+	key        string
+	value      []byte
+}
+
+// fault selects the ledger calls to fail: op is "GetState", "PutState" or
+// "DelState", and an empty key matches every key.
+type fault struct {
+	op  string
+	key string
+}
+
+func newTestStub() *testStub {
+	cc := new(SimpleChaincode)
+	return &testStub{
+		MockStub: shim.NewMockStub("simple", cc),
+		cc:       cc,
+		faults:   make(map[fault]error),
+		history:  make(map[string][]*queryresult.KeyModification),
+	}
+}
+
+// fail makes every later op on key return err.
+func (s *testStub) fail(op, key string, err error) {
+	s.faults[fault{op, key}] = err
+}
+
+// heal removes every injected fault.
+func (s *testStub) heal() {
+	s.faults = make(map[fault]error)
+}
+
+func (s *testStub) faultFor(op, key string) error {
+	if err, ok := s.faults[fault{op, key}]; ok {
+		return err
+	}
+	return s.faults[fault{op, ""}]
+}
+
+func (s *testStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *testStub) GetStringArgs() []string {
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = string(arg)
+	}
+	return args
+}
+
+func (s *testStub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *testStub) GetState(key string) ([]byte, error) {
+	if err := s.faultFor("GetState", key); err != nil {
+		return nil, err
+	}
+	return s.MockStub.GetState(key)
+}
+
+func (s *testStub) PutState(key string, value []byte) error {
+	if err := s.faultFor("PutState", key); err != nil {
+		return err
+	}
+	if s.TxID == "" {
+		return s.MockStub.PutState(key, value)
+	}
+	s.writes = append(s.writes, write{key: key, value: value})
+	return nil
+}
+
+func (s *testStub) DelState(key string) error {
+	if err := s.faultFor("DelState", key); err != nil {
+		return err
+	}
+	s.writes = append(s.writes, write{key: key})
+	return nil
+}
+
+func (s *testStub) PutPrivateData(collection, key string, value []byte) error {
+	s.writes = append(s.writes, write{collection: collection, key: key, value: value})
+	return nil
+}
+
+// commit applies the writes of the running transaction.
+func (s *testStub) commit() error {
+	for _, w := range s.writes {
+		var err error
+		switch {
+		case w.collection != "":
+			err = s.MockStub.PutPrivateData(w.collection, w.key, w.value)
+		case len(w.value) == 0:
+			err = s.MockStub.DelState(w.key)
+			s.record(w.key, nil, true)
+		default:
+			err = s.MockStub.PutState(w.key, w.value)
+			s.record(w.key, w.value, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record appends a write by the current transaction to the history of key.
+func (s *testStub) record(key string, value []byte, isDelete bool) {
+	ts, _ := s.MockStub.GetTxTimestamp()
+	s.history[key] = append(s.history[key], &queryresult.KeyModification{TxId: s.TxID, Value: value, Timestamp: ts, IsDelete: isDelete})
+}
+
+// GetStateByRangeWithPagination pages through the simple keys from startKey
+// to endKey the way a peer does: the bookmark is the first key of the next
+// page, and empty once there is none.
+func (s *testStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	iter := &kvIterator{}
+	meta := &pb.QueryResponseMetadata{}
+	for elem := s.Keys.Front(); elem != nil; elem = elem.Next() {
+		key := elem.Value.(string)
+		if strings.HasPrefix(key, "\x00") || key < startKey || (endKey != "" && key >= endKey) {
+			continue
+		}
+		if int32(len(iter.kvs)) == pageSize {
+			meta.Bookmark = key
+			break
+		}
+		iter.kvs = append(iter.kvs, &queryresult.KV{Key: key, Value: s.State[key]})
+	}
+	meta.FetchedRecordsCount = int32(len(iter.kvs))
+	return iter, meta, nil
+}
+
+func (s *testStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{mods: s.history[key]}, nil
+}
+
+// kvIterator walks a fixed list of key-value pairs.
+type kvIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *kvIterator) HasNext() bool {
+	return len(it.kvs) > 0
+}
+
+func (it *kvIterator) Next() (*queryresult.KV, error) {
+	if len(it.kvs) == 0 {
+		return nil, errors.New("no more results")
+	}
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+func (it *kvIterator) Close() error {
+	return nil
+}
+
+// historyIterator walks a fixed list of key modifications.
+type historyIterator struct {
+	mods []*queryresult.KeyModification
+}
+
+func (it *historyIterator) HasNext() bool {
+	return len(it.mods) > 0
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if len(it.mods) == 0 {
+		return nil, errors.New("no more results")
+	}
+	mod := it.mods[0]
+	it.mods = it.mods[1:]
+	return mod, nil
+}
+
+func (it *historyIterator) Close() error {
+	return nil
+}
+
+func (s *testStub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+func (s *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	if s.now.IsZero() {
+		return s.MockStub.GetTxTimestamp()
+	}
+	return ptypes.TimestampProto(s.now)
+}
+
+// run executes one transaction submitted by caller.
+func (s *testStub) run(caller *identity, invoke func(shim.ChaincodeStubInterface) pb.Response, args []string) pb.Response {
+	s.args = make([][]byte, len(args))
+	for i, arg := range args {
+		s.args[i] = []byte(arg)
+	}
+	s.Creator = caller.creator
+	s.seq++
+	txID := fmt.Sprintf("tx%d", s.seq)
+
+	s.MockTransactionStart(txID)
+	res := invoke(s)
+	ok := res.Status < shim.ERRORTHRESHOLD
+	if ok {
+		if err := s.commit(); err != nil {
+			res = shim.Error(err.Error())
+		}
+	}
+	s.writes = nil
+	s.MockTransactionEnd(txID)
+	s.transient = nil
+
+	// A failed transaction commits neither its writes nor its event.
+	s.lastEvent = nil
+	for len(s.ChaincodeEventsChannel) > 0 {
+		s.lastEvent = <-s.ChaincodeEventsChannel
+	}
+	if !ok {
+		s.lastEvent = nil
+	}
+	return res
+}
+
+// init calls Init as caller with args.
+func (s *testStub) init(caller *identity, args ...string) pb.Response {
+	return s.run(caller, s.cc.Init, append([]string{"init"}, args...))
+}
+
+// invoke calls Invoke as caller with a function name and its args.
+func (s *testStub) invoke(caller *identity, args ...string) pb.Response {
+	return s.run(caller, s.cc.Invoke, args)
+}
+
+// identity is a test client with a self-signed certificate.
+type identity struct {
+	name    string
+	creator []byte
+}
+
+// newIdentity returns a client of mspID whose certificate has common name cn.
+func newIdentity(t *testing.T, mspID, cn string) *identity {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &identity{name: cn, creator: creator}
+}
+
+// id returns the identity string the chaincode records for caller.
+func (s *testStub) id(t *testing.T, caller *identity) string {
+	res := s.invoke(caller, "whoami")
+	if res.Status != shim.OK {
+		t.Fatalf("whoami as %s: %s", caller.name, res.Message)
+	}
+	return string(res.Payload)
+}
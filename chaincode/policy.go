@@ -0,0 +1,336 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Composite key namespaces of spending policies and of the outflows counted
+// against their daily caps.
+const (
+	policyObjectType  = "policy"
+	outflowObjectType = "outflow"
+)
+
+// outflowWindow is the period a daily cap applies to. It rolls with the
+// transaction timestamp rather than resetting at midnight.
+const outflowWindow = 24 * time.Hour
+
+// policy restricts what may be paid out of an account. An account without
+// one is unrestricted.
+type policy struct {
+	Account string `json:"account"`
+	// Limits are keyed by asset symbol.
+	Limits map[string]*spendingLimit `json:"limits,omitempty"`
+	// Allow, when set, lists the only accounts that may be paid. Deny lists
+	// accounts that may not be. At most one of them is set.
+	Allow     []string `json:"allow,omitempty"`
+	Deny      []string `json:"deny,omitempty"`
+	UpdatedBy string   `json:"updatedBy"`
+	UpdatedTx string   `json:"updatedTx"`
+}
+
+// spendingLimit caps the outflow of one asset. A nil field is no limit.
+type spendingLimit struct {
+	MaxTransfer *Amount `json:"maxTransfer,omitempty"`
+	DailyCap    *Amount `json:"dailyCap,omitempty"`
+}
+
+// policySpec is the setPolicy argument.
+type policySpec struct {
+	Limits map[string]struct {
+		MaxTransfer string `json:"maxTransfer"`
+		DailyCap    string `json:"dailyCap"`
+	} `json:"limits"`
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// outflow is what an account paid out of one asset within outflowWindow.
+type outflow struct {
+	Account string         `json:"account"`
+	Asset   string         `json:"asset"`
+	Entries []outflowEntry `json:"entries"`
+}
+
+type outflowEntry struct {
+	At     string `json:"at"`
+	Amount Amount `json:"amount"`
+	TxID   string `json:"txid"`
+}
+
+// debit is one payment checked against a policy.
+type debit struct {
+	to     string
+	amount Amount
+}
+
+// policyChangedEvent is emitted when a policy is set or removed.
+type policyChangedEvent struct {
+	eventHeader
+	Account string  `json:"account"`
+	Policy  *policy `json:"policy,omitempty"`
+}
+
+// getPolicy reads the policy of id, or nil if it has none.
+func getPolicy(stub shim.ChaincodeStubInterface, id string) (*policy, error) {
+	key, err := stub.CreateCompositeKey(policyObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get policy of " + id)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	p := &policy{}
+	if err := json.Unmarshal(value, p); err != nil {
+		return nil, fmt.Errorf("Corrupt policy of %s: %s", id, err)
+	}
+	return p, nil
+}
+
+func getOutflow(stub shim.ChaincodeStubInterface, id, symbol string) (*outflow, error) {
+	key, err := stub.CreateCompositeKey(outflowObjectType, []string{id, symbol})
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s outflow of %s", symbol, id)
+	}
+	o := &outflow{Account: id, Asset: symbol}
+	if value == nil {
+		return o, nil
+	}
+	if err := json.Unmarshal(value, o); err != nil {
+		return nil, fmt.Errorf("Corrupt %s outflow of %s: %s", symbol, id, err)
+	}
+	return o, nil
+}
+
+func putOutflow(stub shim.ChaincodeStubInterface, o *outflow) error {
+	key, err := stub.CreateCompositeKey(outflowObjectType, []string{o.Account, o.Asset})
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, value)
+}
+
+// enforcePolicy checks debits out of acct in a against acct's policy and
+// counts them as outflow. Outflow is counted whether or not the policy has a
+// daily cap, so that a cap set later covers the payments of the last day;
+// payments made while the account had no policy at all are not counted. Call
+// it once per transaction, after the balance check and before anything is
+// written.
+func enforcePolicy(stub shim.ChaincodeStubInterface, acct *account, a *asset, debits ...debit) error {
+	p, err := getPolicy(stub, acct.ID)
+	if err != nil || p == nil {
+		return err
+	}
+	limit := p.Limits[a.Symbol]
+	total := Amount{}.quantize(a.Decimals)
+	for _, d := range debits {
+		if len(p.Allow) > 0 && !contains(p.Allow, d.to) {
+			return newCodedError(codeCounterpartyNotAllowed, "%s may only pay accounts on its allowlist, not %s", acct.ID, d.to)
+		}
+		if contains(p.Deny, d.to) {
+			return newCodedError(codeCounterpartyDenied, "%s may not pay %s", acct.ID, d.to)
+		}
+		if limit != nil && limit.MaxTransfer != nil && d.amount.Cmp(*limit.MaxTransfer) > 0 {
+			return newCodedError(codeTransferLimitExceeded, "%s may pay at most %s %s at once, not %s", acct.ID, *limit.MaxTransfer, a.Symbol, d.amount)
+		}
+		total = total.Add(d.amount)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	o, err := getOutflow(stub, acct.ID, a.Symbol)
+	if err != nil {
+		return err
+	}
+	since := now.Add(-outflowWindow)
+	spent := Amount{}.quantize(a.Decimals)
+	entries := o.Entries[:0]
+	for _, entry := range o.Entries {
+		at, err := time.Parse(time.RFC3339Nano, entry.At)
+		if err != nil {
+			return fmt.Errorf("Corrupt %s outflow of %s: %s", a.Symbol, acct.ID, err)
+		}
+		if at.After(since) {
+			entries = append(entries, entry)
+			spent = spent.Add(entry.Amount)
+		}
+	}
+	if limit != nil && limit.DailyCap != nil && spent.Add(total).Cmp(*limit.DailyCap) > 0 {
+		return newCodedError(codeDailyLimitExceeded, "%s has paid %s of its daily %s %s cap, cannot pay %s more", acct.ID, spent, *limit.DailyCap, a.Symbol, total)
+	}
+	o.Entries = append(entries, outflowEntry{At: now.UTC().Format(time.RFC3339Nano), Amount: total, TxID: stub.GetTxID()})
+	return putOutflow(stub, o)
+}
+
+// dropOutflow stops counting what transaction txID paid out of account id in
+// symbol, for a pending transfer that was returned rather than paid out.
+func dropOutflow(stub shim.ChaincodeStubInterface, id, symbol, txID string) error {
+	o, err := getOutflow(stub, id, symbol)
+	if err != nil {
+		return err
+	}
+	entries := o.Entries[:0]
+	for _, e := range o.Entries {
+		if e.TxID != txID {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == len(o.Entries) {
+		return nil
+	}
+	o.Entries = entries
+	return putOutflow(stub, o)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets the spending policy of an account from a JSON document such as
+// {"limits":{"UNIT":{"maxTransfer":"100","dailyCap":"500"}},"deny":["X"]}.
+// An empty document {} removes the policy. Only the owner of the account or
+// an admin may change it.
+func (t *SimpleChaincode) setPolicy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	A := args[0]
+	var spec policySpec
+	if err := decodeStrict(args[1], &spec); err != nil {
+		return nil, newArgumentError(codeInvalidArgument, "policy", "Invalid policy: %s", err)
+	}
+	if len(spec.Allow) > 0 && len(spec.Deny) > 0 {
+		return nil, newArgumentError(codeInvalidArgument, "policy", "Invalid policy: give an allowlist or a denylist, not both")
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	acct, err := loadAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller != acct.Owner && !cfg.isAdmin(caller) {
+		return nil, newCodedError(codeUnauthorized, "Only the owner of %s or an admin may change its policy", A)
+	}
+
+	p := &policy{
+		Account:   A,
+		Allow:     spec.Allow,
+		Deny:      spec.Deny,
+		UpdatedBy: caller,
+		UpdatedTx: stub.GetTxID(),
+	}
+	for symbol, l := range spec.Limits {
+		a, err := getAsset(stub, cfg, symbol)
+		if err != nil {
+			return nil, withArgument(err, "policy")
+		}
+		limit := &spendingLimit{}
+		if l.MaxTransfer != "" {
+			X, err := parseTransferAmount(l.MaxTransfer, a.Decimals)
+			if err != nil {
+				return nil, withArgument(err, "policy")
+			}
+			limit.MaxTransfer = &X
+		}
+		if l.DailyCap != "" {
+			X, err := parseTransferAmount(l.DailyCap, a.Decimals)
+			if err != nil {
+				return nil, withArgument(err, "policy")
+			}
+			limit.DailyCap = &X
+		}
+		if p.Limits == nil {
+			p.Limits = make(map[string]*spendingLimit)
+		}
+		p.Limits[symbol] = limit
+	}
+
+	key, err := stub.CreateCompositeKey(policyObjectType, []string{A})
+	if err != nil {
+		return nil, err
+	}
+	event := &policyChangedEvent{eventHeader: newEventHeader(stub), Account: A}
+	if p.Limits == nil && len(p.Allow) == 0 && len(p.Deny) == 0 {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, errors.New("Failed to delete state")
+		}
+	} else {
+		value, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		err = stub.PutState(key, value)
+		if err != nil {
+			return nil, err
+		}
+		event.Policy = p
+	}
+
+	err = setEvent(stub, eventPolicyChanged, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Returns the spending policy of an account, or null if it has none.
+func (t *SimpleChaincode) policyQuery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	acct, err := getPublicAccount(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, newArgumentError(codeEntityNotFound, "entity", "Entity %s not found", args[0])
+	}
+	p, err := getPolicy(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(p)
+}
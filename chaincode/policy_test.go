@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestSpendingLimits(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "setPolicy", "A", `{"limits":{"UNIT":{"maxTransfer":"20","dailyCap":"30"}}}`))
+	f.checkEvent(t, eventPolicyChanged)
+
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "21"), shim.ERRORTHRESHOLD, codeTransferLimitExceeded)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "20"))
+	f.now = f.now.Add(12 * time.Hour)
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "11"), shim.ERRORTHRESHOLD, codeDailyLimitExceeded)
+	checkFailed(t, f.invoke(f.admin, "batchTransfer", "A", `[{"to":"B","amount":"6"},{"to":"B","amount":"5"}]`), shim.ERRORTHRESHOLD, codeDailyLimitExceeded)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "10"))
+	f.checkBalance(t, "A", "70")
+
+	// The cap rolls: the first payment leaves the window 24 hours after it.
+	f.now = f.now.Add(12 * time.Hour)
+	checkFailed(t, f.invoke(f.admin, "escrowOpen", "e1", "A", "B", "arb", "21", f.now.Add(time.Hour).Format(time.RFC3339)), shim.ERRORTHRESHOLD, codeTransferLimitExceeded)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "20"))
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1"), shim.ERRORTHRESHOLD, codeDailyLimitExceeded)
+
+	// Other accounts and incoming payments are unaffected.
+	checkOK(t, f.invoke(f.admin, "transfer", "B", "A", "100"))
+
+	checkOK(t, f.invoke(f.admin, "setPolicy", "A", `{}`))
+	res := f.invoke(f.alice, "policy", "A")
+	checkOK(t, res)
+	if string(res.Payload) != "null" {
+		t.Fatalf("expected the policy to be removed, got %s", res.Payload)
+	}
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "50"))
+}
+
+func TestCounterpartyLists(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "create", "C"))
+
+	checkOK(t, f.invoke(f.admin, "setPolicy", "A", `{"deny":["B"]}`))
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1"), shim.ERRORTHRESHOLD, codeCounterpartyDenied)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "C", "1"))
+
+	checkOK(t, f.invoke(f.admin, "setPolicy", "A", `{"allow":["C"]}`))
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1"), shim.ERRORTHRESHOLD, codeCounterpartyNotAllowed)
+	checkFailed(t, f.invoke(f.admin, "batchTransfer", "A", `[{"to":"C","amount":"1"},{"to":"B","amount":"1"}]`), shim.ERRORTHRESHOLD, codeCounterpartyNotAllowed)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "C", "1"))
+	f.checkBalance(t, "C", "2")
+}
+
+func TestSetPolicy(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.alice, "create", "D"))
+	checkOK(t, f.invoke(f.alice, "setPolicy", "D", `{"deny":["A"]}`))
+	checkOK(t, f.invoke(f.admin, "setPolicy", "D", `{}`))
+
+	tests := []struct {
+		name    string
+		caller  *identity
+		args    []string
+		status  int32
+		message string
+	}{
+		{name: "not owner", caller: f.alice, args: []string{"A", `{"deny":["B"]}`}, status: shim.ERRORTHRESHOLD, message: codeUnauthorized},
+		{name: "allow and deny", caller: f.admin, args: []string{"A", `{"allow":["B"],"deny":["C"]}`}, status: shim.ERRORTHRESHOLD, message: `"argument":"policy"`},
+		{name: "unknown field", caller: f.admin, args: []string{"A", `{"max":"1"}`}, status: shim.ERRORTHRESHOLD, message: codeInvalidArgument},
+		{name: "unknown asset", caller: f.admin, args: []string{"A", `{"limits":{"GOLD":{"dailyCap":"1"}}}`}, status: shim.ERRORTHRESHOLD, message: codeUnknownAsset},
+		{name: "negative limit", caller: f.admin, args: []string{"A", `{"limits":{"UNIT":{"maxTransfer":"-1"}}}`}, status: shim.ERRORTHRESHOLD, message: codeNegativeAmount},
+		{name: "limit blames the policy", caller: f.admin, args: []string{"A", `{"limits":{"UNIT":{"dailyCap":"0"}}}`}, status: shim.ERRORTHRESHOLD, message: `"argument":"policy"`},
+		{name: "missing account", caller: f.admin, args: []string{"Z", `{}`}, status: shim.ERRORTHRESHOLD, message: codeEntityNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkFailed(t, f.invoke(test.caller, append([]string{"setPolicy"}, test.args...)...), test.status, test.message)
+		})
+	}
+}
+
+func TestNewDailyCapCountsRecentPayments(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "setPolicy", "A", `{"deny":["X"]}`))
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "25"))
+
+	f.now = f.now.Add(time.Hour)
+	checkOK(t, f.invoke(f.admin, "setPolicy", "A", `{"limits":{"UNIT":{"dailyCap":"30"}}}`))
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "6"), shim.ERRORTHRESHOLD, codeDailyLimitExceeded)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "5"))
+}
@@ -0,0 +1,211 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Transient map entries read by createPrivate and by transfers with a
+// private account. Transient data reaches the endorsers but is not recorded
+// in the transaction.
+const (
+	transientSalt    = "salt"
+	transientBalance = "balance"
+	transientAmount  = "amount"
+)
+
+// minSaltLength keeps private balances from being found by hashing guesses.
+const minSaltLength = 16
+
+// privateBalance is the public part of a private account: where its balance
+// is kept, which organizations may read it, and a salted hash that commits
+// to the value.
+type privateBalance struct {
+	Collection string   `json:"collection"`
+	Members    []string `json:"members"`
+	// Hash is hex(SHA-256(salt || balance)).
+	Hash string `json:"hash"`
+}
+
+// privateRecord is what a private account keeps in its collection.
+type privateRecord struct {
+	ID      string `json:"id"`
+	Balance Amount `json:"balance"`
+	Salt    []byte `json:"salt"`
+}
+
+// balanceHash commits to balance without revealing it to those who lack salt.
+func balanceHash(salt []byte, balance Amount) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(balance.String()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadPrivateBalance reads the balance of a private account from its
+// collection. It fails on peers outside the collection, and when the value
+// does not match the hash on the public ledger.
+func loadPrivateBalance(stub shim.ChaincodeStubInterface, acct *account) error {
+	collection := acct.Private.Collection
+	value, err := stub.GetPrivateData(collection, acct.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to get private state for %s: %s", acct.ID, err)
+	}
+	if value == nil {
+		return newCodedError(codePrivateDataUnavailable, "Balance of %s is not available on this peer, which is not in collection %s", acct.ID, collection)
+	}
+	record := &privateRecord{}
+	if err := json.Unmarshal(value, record); err != nil {
+		return fmt.Errorf("Corrupt private state for %s: %s", acct.ID, err)
+	}
+	if record.ID != acct.ID || balanceHash(record.Salt, record.Balance) != acct.Private.Hash {
+		return fmt.Errorf("Corrupt private state for %s: does not match the public hash", acct.ID)
+	}
+	acct.Balance = record.Balance
+	acct.loaded = record.Balance
+	acct.salt = record.Salt
+	return nil
+}
+
+// putPrivateBalance writes the balance of a private account to its
+// collection and updates the public hash.
+func putPrivateBalance(stub shim.ChaincodeStubInterface, acct *account) error {
+	value, err := json.Marshal(&privateRecord{ID: acct.ID, Balance: acct.Balance, Salt: acct.salt})
+	if err != nil {
+		return err
+	}
+	if err := stub.PutPrivateData(acct.Private.Collection, acct.ID, value); err != nil {
+		return fmt.Errorf("Failed to put private state for %s: %s", acct.ID, err)
+	}
+	acct.Private.Hash = balanceHash(acct.salt, acct.Balance)
+	return nil
+}
+
+// privateAmount reads the amount of a transfer with a private account from
+// the transient map, so that it stays out of the transaction. The public
+// amount argument, arg, must be zero.
+func privateAmount(stub shim.ChaincodeStubInterface, arg string, scale int) (Amount, error) {
+	public, err := parseAmount(arg)
+	if err != nil || public.Sign() != 0 {
+		return Amount{}, newCodedError(codeInvalidArgument, "Transfers with a private account take their amount from transient %q; pass 0 as the amount", transientAmount)
+	}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return Amount{}, fmt.Errorf("Failed to get transient data: %s", err)
+	}
+	value, ok := transient[transientAmount]
+	if !ok {
+		return Amount{}, newCodedError(codeInvalidArgument, "Transient %q must hold the amount of a transfer with a private account", transientAmount)
+	}
+	return parseTransferAmount(string(value), scale)
+}
+
+// isMember reports whether mspID may read the balance of acct.
+func (p *privateBalance) isMember(mspID string) bool {
+	for _, member := range p.Members {
+		if member == mspID {
+			return true
+		}
+	}
+	return false
+}
+
+// requireMember fails with an authorization error unless the caller's
+// organization is a member of the collection holding acct's balance.
+func requireMember(stub shim.ChaincodeStubInterface, acct *account) error {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return fmt.Errorf("Failed to read transaction creator: %s", err)
+	}
+	if !acct.Private.isMember(mspID) {
+		return newCodedError(codeUnauthorized, "Balance of %s is private to collection %s", acct.ID, acct.Private.Collection)
+	}
+	return nil
+}
+
+// Opens an account whose balance is kept in a private data collection:
+// entity, collection, members. Members is a JSON array of the MSP IDs that
+// belong to the collection and may query the balance; it must include the
+// caller's. The salt is passed in the transient map so that it stays off the
+// ledger. The account opens empty and is funded by transfers: an opening
+// balance would issue funds, and the supply record and journal would show
+// the amount.
+func (t *SimpleChaincode) createPrivate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	spec := accountSpec{ID: args[0]}
+	private := &privateBalance{Collection: args[1]}
+	if err := decodeStrict(args[2], &private.Members); err != nil {
+		return nil, newCodedError(codeInvalidArgument, "Invalid member list: %s", err)
+	}
+	if len(private.Members) == 0 {
+		return nil, newCodedError(codeInvalidArgument, "Invalid member list: no members given")
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get transient data: %s", err)
+	}
+	salt := transient[transientSalt]
+	if len(salt) < minSaltLength {
+		return nil, newCodedError(codeInvalidArgument, "Transient %q must hold at least %d bytes", transientSalt, minSaltLength)
+	}
+	if _, ok := transient[transientBalance]; ok {
+		return nil, newCodedError(codeInvalidArgument, "Private account %s opens with no balance; fund it with a transfer", spec.ID)
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read transaction creator: %s", err)
+	}
+	if !private.isMember(mspID) {
+		return nil, newCodedError(codeInvalidArgument, "Member list must include the owner's organization %s", mspID)
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	acct, err := openAccount(stub, cfg, caller, spec)
+	if err != nil {
+		return nil, err
+	}
+	acct.Private = private
+	acct.salt = salt
+	fmt.Printf("Created private %s in %s\n", acct.ID, private.Collection)
+
+	err = putAccount(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventAccountsCreated, newAccountsCreatedEvent(stub, acct))
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,185 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	testCollection = "org1Balances"
+	testSalt       = "0123456789abcdef"
+)
+
+// newPrivateFixture opens P in testCollection, readable by Org1MSP, and
+// funds it with 50 from A.
+func newPrivateFixture(t *testing.T) *fixture {
+	f := newFixture(t)
+	f.transient = map[string][]byte{transientSalt: []byte(testSalt)}
+	checkOK(t, f.invoke(f.admin, "createPrivate", "P", testCollection, `["Org1MSP"]`))
+	if strings.Contains(string(f.lastEvent.Payload), "balance") {
+		t.Fatalf("AccountsCreated event reveals the balance: %s", f.lastEvent.Payload)
+	}
+	checkOK(t, f.payPrivate("A", "P", "50"))
+	return f
+}
+
+// payPrivate transfers amount between from and to, at least one of them
+// private, passing the amount in the transient map.
+func (f *fixture) payPrivate(from, to, amount string) pb.Response {
+	f.transient = map[string][]byte{transientAmount: []byte(amount)}
+	return f.invoke(f.admin, "transfer", from, to, "0")
+}
+
+// publicDocument returns the account document of id on the public ledger.
+func (f *fixture) publicDocument(t *testing.T, id string) map[string]interface{} {
+	t.Helper()
+	value, err := f.MockStub.GetState(id)
+	if err != nil || value == nil {
+		t.Fatalf("no state for %s: %v", id, err)
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(value, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestPrivateAccountKeepsBalanceOffLedger(t *testing.T) {
+	f := newPrivateFixture(t)
+	doc := f.publicDocument(t, "P")
+	if _, ok := doc["balance"]; ok {
+		t.Fatalf("public document reveals the balance: %v", doc)
+	}
+	private := doc["private"].(map[string]interface{})
+	balance, _ := parseAmount("50")
+	if private["collection"] != testCollection || private["hash"] != balanceHash([]byte(testSalt), balance) {
+		t.Fatalf("unexpected private section %v", private)
+	}
+	if f.PvtState[testCollection]["P"] == nil {
+		t.Fatal("balance missing from the collection")
+	}
+
+	res := f.invoke(f.alice, "prefix", "currency~id", defaultCurrency)
+	checkOK(t, res)
+	if i := strings.Index(string(res.Payload), `"id":"P"`); i < 0 || strings.Contains(string(res.Payload[i:]), `"balance"`) {
+		t.Fatalf("prefix should list P without its balance: %s", res.Payload)
+	}
+}
+
+func TestPrivateAccountTransfers(t *testing.T) {
+	f := newPrivateFixture(t)
+	checkOK(t, f.payPrivate("A", "P", "10"))
+	f.checkBalance(t, "P", "60")
+	checkOK(t, f.payPrivate("P", "B", "25"))
+	f.checkBalance(t, "P", "35")
+	f.checkBalance(t, "B", "225")
+	checkFailed(t, f.payPrivate("P", "B", "36"), shim.ERRORTHRESHOLD, codeInsufficientFunds)
+
+	balance, _ := parseAmount("35")
+	private := f.publicDocument(t, "P")["private"].(map[string]interface{})
+	if private["hash"] != balanceHash([]byte(testSalt), balance) {
+		t.Fatalf("public hash not updated: %v", private)
+	}
+
+	checkOK(t, f.invoke(f.admin, "delete", "P"))
+	if tomb := f.publicDocument(t, "P")["tombstone"].(map[string]interface{}); tomb["finalBalance"] != nil {
+		t.Fatalf("tombstone reveals the balance: %v", tomb)
+	}
+}
+
+func TestPrivateTransferKeepsAmountOffLedger(t *testing.T) {
+	f := newPrivateFixture(t)
+	f.transient = nil
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "P", "10"), shim.ERRORTHRESHOLD, "pass 0 as the amount")
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "P", "0"), shim.ERRORTHRESHOLD, "must hold the amount")
+
+	checkOK(t, f.payPrivate("A", "P", "10"))
+	f.checkEvent(t, eventTransfer)
+	if strings.Contains(string(f.lastEvent.Payload), `"amount"`) {
+		t.Fatalf("Transfer event reveals the amount: %s", f.lastEvent.Payload)
+	}
+}
+
+func TestPrivateAccountQuery(t *testing.T) {
+	f := newPrivateFixture(t)
+	checkOK(t, f.invoke(f.alice, "query", "P"))
+	outsider := newIdentity(t, "Org2MSP", "bob")
+	checkFailed(t, f.invoke(outsider, "query", "P"), shim.ERRORTHRESHOLD, codeUnauthorized)
+
+	// A peer outside the collection has the public document only.
+	delete(f.PvtState[testCollection], "P")
+	checkFailed(t, f.invoke(f.admin, "query", "P"), shim.ERROR, codePrivateDataUnavailable)
+	checkFailed(t, f.payPrivate("A", "P", "1"), shim.ERROR, codePrivateDataUnavailable)
+	f.checkBalance(t, "A", "50")
+}
+
+func TestPrivateOpeningIssuesNothing(t *testing.T) {
+	f := newFixture(t)
+	f.transient = map[string][]byte{transientSalt: []byte(testSalt), transientBalance: []byte("50")}
+	checkFailed(t, f.invoke(f.admin, "createPrivate", "P", testCollection, `["Org1MSP"]`), shim.ERRORTHRESHOLD, "fund it with a transfer")
+
+	f.transient = map[string][]byte{transientSalt: []byte(testSalt)}
+	checkOK(t, f.invoke(f.admin, "createPrivate", "P", testCollection, `["Org1MSP"]`))
+	f.checkSupply(t, defaultCurrency, "300")
+	key, _ := f.CreateCompositeKey(journalObjectType, []string{fmt.Sprintf("tx%d", f.seq)})
+	if entry := f.State[key]; entry != nil {
+		t.Fatalf("opening P was journaled: %s", entry)
+	}
+
+	// Funding it moves funds rather than issuing them.
+	checkOK(t, f.payPrivate("A", "P", "50"))
+	f.checkSupply(t, defaultCurrency, "300")
+}
+
+func TestCreatePrivate(t *testing.T) {
+	tests := []struct {
+		name      string
+		caller    string
+		members   string
+		transient map[string][]byte
+		message   string
+	}{
+		{name: "no salt", members: `["Org1MSP"]`, transient: map[string][]byte{}, message: codeInvalidArgument},
+		{name: "short salt", members: `["Org1MSP"]`, transient: map[string][]byte{transientSalt: []byte("short")}, message: codeInvalidArgument},
+		{name: "caller not member", members: `["Org2MSP"]`, transient: map[string][]byte{transientSalt: []byte(testSalt)}, message: codeInvalidArgument},
+		{name: "no members", members: `[]`, transient: map[string][]byte{transientSalt: []byte(testSalt)}, message: codeInvalidArgument},
+		{name: "opening balance", members: `["Org1MSP"]`, transient: map[string][]byte{transientSalt: []byte(testSalt), transientBalance: []byte("5")}, message: codeInvalidArgument},
+		{name: "zero opening balance", caller: "alice", members: `["Org1MSP"]`, transient: map[string][]byte{transientSalt: []byte(testSalt), transientBalance: []byte("0")}, message: codeInvalidArgument},
+		{name: "existing name", members: `["Org1MSP"]`, transient: map[string][]byte{transientSalt: []byte(testSalt)}, message: codeAccountExists},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			caller, id := f.admin, "P"
+			if test.caller == "alice" {
+				caller = f.alice
+			}
+			if test.message == codeAccountExists {
+				id = "A"
+			}
+			f.transient = test.transient
+			checkFailed(t, f.invoke(caller, "createPrivate", id, testCollection, test.members), shim.ERRORTHRESHOLD, test.message)
+		})
+	}
+}
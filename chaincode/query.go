@@ -0,0 +1,325 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+	// maxQueryKeys bounds the entities that one queryMany call may name.
+	maxQueryKeys = 500
+)
+
+// balanceDocument is the response of the query function: what one entity
+// holds of one asset.
+type balanceDocument struct {
+	Name   string `json:"name"`
+	Amount Amount `json:"amount"`
+	Asset  string `json:"asset"`
+	Owner  string `json:"owner"`
+	// UpdatedTx is the transaction that last changed the balance. It is
+	// empty for an asset the entity has never held.
+	UpdatedTx string `json:"updatedTx,omitempty"`
+}
+
+// queryResult is one entry of the queryMany response. Exactly one of
+// Balance and Error is set; an entity that does not exist is reported with
+// the ENTITY_NOT_FOUND code.
+type queryResult struct {
+	Name    string           `json:"name"`
+	Balance *balanceDocument `json:"balance,omitempty"`
+	Error   *codedError      `json:"error,omitempty"`
+}
+
+// accountPage is the response of the list query.
+type accountPage struct {
+	Accounts []*account `json:"accounts"`
+	Count    int        `json:"count"`
+	Bookmark string     `json:"bookmark"`
+}
+
+// historyEntry is one past value of an account.
+type historyEntry struct {
+	TxID      string   `json:"txId"`
+	Timestamp string   `json:"timestamp"`
+	IsDelete  bool     `json:"isDelete"`
+	Account   *account `json:"account"`
+}
+
+// queryAsset resolves the asset argument of a query.
+func queryAsset(stub shim.ChaincodeStubInterface, symbol string) (*asset, error) {
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	a, err := getAsset(stub, cfg, symbol)
+	if err != nil {
+		return nil, withArgument(err, "asset")
+	}
+	return a, nil
+}
+
+// readBalance reads what entity id holds of a, or of its own currency when a
+// is nil. Members of a private account's collection read its private balance;
+// everyone else is refused.
+func readBalance(stub shim.ChaincodeStubInterface, id string, a *asset) (*balanceDocument, error) {
+	acct, err := getPublicAccount(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, newArgumentError(codeEntityNotFound, "entity", "Entity %s not found", id)
+	}
+	err = requireLive(acct)
+	if err != nil {
+		return nil, err
+	}
+
+	if acct.Private != nil {
+		err = requireMember(stub, acct)
+		if err != nil {
+			return nil, err
+		}
+		err = loadPrivateBalance(stub, acct)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	doc := &balanceDocument{
+		Name:      acct.ID,
+		Amount:    acct.Balance,
+		Asset:     acct.Currency,
+		Owner:     acct.Owner,
+		UpdatedTx: acct.UpdatedTx,
+	}
+	if a == nil || a.Symbol == acct.Currency {
+		return doc, nil
+	}
+
+	if acct.Private != nil {
+		return nil, newCodedError(codeInvalidArgument, "Private account %s holds only %s", acct.ID, acct.Currency)
+	}
+	h, err := getHolding(stub, acct, a)
+	if err != nil {
+		return nil, err
+	}
+	doc.Asset = a.Symbol
+	if h == nil {
+		doc.Amount = Amount{}.quantize(a.Decimals)
+		doc.UpdatedTx = ""
+	} else {
+		doc.Amount = h.Balance
+		doc.UpdatedTx = h.UpdatedTx
+	}
+	return doc, nil
+}
+
+// Returns the balances of a JSON list of entities in one call, in the order
+// given. A failure that concerns a single entity, such as one that does not
+// exist, is reported in its entry; any other failure fails the whole query.
+func (t *SimpleChaincode) queryMany(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var ids []string
+	if err := decodeStrict(args[0], &ids); err != nil {
+		return nil, newArgumentError(codeInvalidArgument, "entities", "Invalid entity list: %s", err)
+	}
+	if len(ids) == 0 {
+		return nil, newArgumentError(codeInvalidArgument, "entities", "Invalid entity list: no entities given")
+	}
+	if len(ids) > maxQueryKeys {
+		return nil, newArgumentError(codeInvalidArgument, "entities", "Invalid entity list: %d entities exceed the maximum of %d", len(ids), maxQueryKeys)
+	}
+
+	for _, id := range ids {
+		if id == "" {
+			return nil, newArgumentError(codeInvalidArgument, "entities", "Invalid entity list: names must not be empty")
+		}
+	}
+
+	var a *asset
+	if len(args) > 1 {
+		var err error
+		a, err = queryAsset(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]queryResult, len(ids))
+	for i, id := range ids {
+		results[i].Name = id
+		doc, err := readBalance(stub, id, a)
+		if coded, ok := err.(*codedError); ok && coded.status() != shim.ERROR {
+			results[i].Error = coded
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		results[i].Balance = doc
+	}
+
+	return json.Marshal(results)
+}
+
+// Pages through every account in key order. Pass the returned bookmark to
+// fetch the next page; an empty bookmark means there are no more.
+func (t *SimpleChaincode) list(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	pageSize := defaultPageSize
+	if len(args) > 0 {
+		var err error
+		pageSize, err = strconv.Atoi(args[0])
+		if err != nil || pageSize < 1 {
+			return nil, newArgumentError(codeInvalidArgument, "pageSize", "Argument pageSize of list must be a positive integer, got %q", args[0])
+		}
+		if pageSize > maxPageSize {
+			return nil, newArgumentError(codeInvalidArgument, "pageSize", "Page size %d exceeds the maximum of %d", pageSize, maxPageSize)
+		}
+	}
+	bookmark := ""
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+
+	// Every record other than an account lives under a composite key, so
+	// the plain key range holds accounts only.
+	iter, meta, err := stub.GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list accounts: %s", err)
+	}
+	if iter == nil {
+		return nil, errors.New("Failed to list accounts: the peer returned no results")
+	}
+	defer iter.Close()
+
+	page := accountPage{Accounts: []*account{}}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list accounts: %s", err)
+		}
+		acct, err := decodeAccount(kv.Key, kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		page.Accounts = append(page.Accounts, acct)
+	}
+	page.Count = len(page.Accounts)
+	if meta != nil {
+		page.Bookmark = meta.Bookmark
+	}
+
+	return json.Marshal(page)
+}
+
+// Returns every value an entity has held, oldest first, with the
+// transaction that wrote it.
+func (t *SimpleChaincode) history(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	A := args[0]
+
+	iter, err := stub.GetHistoryForKey(A)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get history for %s: %s", A, err)
+	}
+	if iter == nil {
+		return nil, fmt.Errorf("Failed to get history for %s: the peer returned no results", A)
+	}
+	defer iter.Close()
+
+	entries := []historyEntry{}
+	for iter.HasNext() {
+		mod, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get history for %s: %s", A, err)
+		}
+		entry := historyEntry{TxID: mod.TxId, IsDelete: mod.IsDelete}
+		if mod.Timestamp != nil {
+			ts, err := ptypes.Timestamp(mod.Timestamp)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid timestamp in history of %s: %s", A, err)
+			}
+			entry.Timestamp = ts.Format(time.RFC3339Nano)
+		}
+		if !mod.IsDelete {
+			entry.Account, err = decodeAccount(A, mod.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return json.Marshal(entries)
+}
+
+// Returns every account listed in an index under the given leading
+// attributes, e.g. all accounts of one currency.
+func (t *SimpleChaincode) prefix(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	index := findAccountIndex(args[0])
+	if index == nil {
+		names := make([]string, len(accountIndexes))
+		for i := range accountIndexes {
+			names[i] = accountIndexes[i].objectType
+		}
+		return nil, newArgumentError(codeInvalidArgument, "index", "Unknown index %q. Expecting one of: %s", args[0], strings.Join(names, ", "))
+	}
+	attributes := args[1:]
+
+	iter, err := stub.GetStateByPartialCompositeKey(index.objectType, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to scan %s: %s", index.objectType, err)
+	}
+	defer iter.Close()
+
+	accts := []*account{}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to scan %s: %s", index.objectType, err)
+		}
+		_, keyParts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) == 0 {
+			return nil, fmt.Errorf("Corrupt index entry in %s", index.objectType)
+		}
+		id := keyParts[len(keyParts)-1]
+
+		acct, err := getPublicAccount(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		if acct == nil {
+			return nil, fmt.Errorf("Index %s refers to missing account %s", index.objectType, id)
+		}
+		accts = append(accts, acct)
+	}
+
+	return json.Marshal(accts)
+}
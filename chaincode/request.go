@@ -0,0 +1,338 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// requestObjectType is the composite key namespace of client request IDs,
+// keyed by caller so that two clients cannot collide.
+const requestObjectType = "request"
+
+// heightObjectType is the composite key namespace of the block height that
+// request IDs expire by.
+const heightObjectType = "height"
+
+// maxRequestIDLength bounds the client request IDs the ledger stores.
+const maxRequestIDLength = 128
+
+// defaultRequestExpiryBlocks is for how many blocks request IDs are
+// remembered unless the configuration says otherwise.
+const defaultRequestExpiryBlocks = 100
+
+// requestConfig sets for how many blocks client request IDs are remembered.
+// Chaincode cannot read the block height itself, so it counts blocks by the
+// height an admin records with setBlockHeight.
+type requestConfig struct {
+	ExpiryBlocks int `json:"expiryBlocks"`
+}
+
+// blockHeight is the last block height recorded by setBlockHeight.
+type blockHeight struct {
+	Height    int    `json:"height"`
+	UpdatedTx string `json:"updatedTx"`
+}
+
+// blockHeightEvent is emitted by setBlockHeight.
+type blockHeightEvent struct {
+	eventHeader
+	Height int `json:"height"`
+}
+
+// requestRecord is the outcome of a request, stored under its ID until it
+// expires. A replay of the request is answered with the encoded record.
+type requestRecord struct {
+	RequestID string `json:"requestId"`
+	Caller    string `json:"caller"`
+	Function  string `json:"function"`
+	// ArgsHash fingerprints the arguments, so that an ID reused for a
+	// different request is refused rather than answered with another
+	// request's outcome.
+	ArgsHash string `json:"argsHash"`
+	TxID     string `json:"txId"`
+	// ExpiresAtBlock is the block height from which the record no longer
+	// answers replays. Records written before expiry was counted in blocks
+	// carry an ExpiresAt time instead.
+	ExpiresAtBlock int             `json:"expiresAtBlock,omitempty"`
+	ExpiresAt      string          `json:"expiresAt,omitempty"`
+	Result         json.RawMessage `json:"result,omitempty"`
+}
+
+// expiredRequests is the response of expireRequests.
+type expiredRequests struct {
+	Removed int `json:"removed"`
+}
+
+// requestsExpiredEvent is emitted by expireRequests.
+type requestsExpiredEvent struct {
+	eventHeader
+	Removed int `json:"removed"`
+}
+
+// validate checks the request options of Init.
+func (r *requestConfig) validate() error {
+	if r.ExpiryBlocks <= 0 {
+		return errors.New("requests.expiryBlocks must be a positive number of blocks")
+	}
+	return nil
+}
+
+// requestExpiryBlocks returns for how many blocks a request ID is
+// remembered. Configurations that gave the expiry as a duration have no
+// block count and use the default.
+func requestExpiryBlocks(cfg *chaincodeConfig) int {
+	if cfg.Requests == nil || cfg.Requests.ExpiryBlocks <= 0 {
+		return defaultRequestExpiryBlocks
+	}
+	return cfg.Requests.ExpiryBlocks
+}
+
+func heightKey(stub shim.ChaincodeStubInterface) (string, error) {
+	return stub.CreateCompositeKey(heightObjectType, []string{"chaincode"})
+}
+
+// getBlockHeight returns the last block height recorded, or zero if none
+// was.
+func getBlockHeight(stub shim.ChaincodeStubInterface) (int, error) {
+	key, err := heightKey(stub)
+	if err != nil {
+		return 0, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return 0, errors.New("Failed to get block height")
+	}
+	if value == nil {
+		return 0, nil
+	}
+	h := &blockHeight{}
+	if err := json.Unmarshal(value, h); err != nil {
+		return 0, fmt.Errorf("Corrupt block height: %s", err)
+	}
+	return h.Height, nil
+}
+
+// expired reports whether rec no longer answers replays at block height,
+// or, for a record that expires by time, at now.
+func (rec *requestRecord) expired(height int, now time.Time) (bool, error) {
+	if rec.ExpiresAt == "" {
+		return height >= rec.ExpiresAtBlock, nil
+	}
+	expires, err := time.Parse(time.RFC3339Nano, rec.ExpiresAt)
+	if err != nil {
+		return false, fmt.Errorf("Corrupt request %s: %s", rec.RequestID, err)
+	}
+	return !now.Before(expires), nil
+}
+
+func requestKey(stub shim.ChaincodeStubInterface, caller, requestID string) (string, error) {
+	return stub.CreateCompositeKey(requestObjectType, []string{caller, requestID})
+}
+
+// argsHash fingerprints a function call.
+func argsHash(function string, args []string) string {
+	value, _ := json.Marshal(append([]string{function}, args...))
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotent runs a request that the caller has tagged with requestID. The
+// first run records its outcome; a replay before the record expires returns
+// that outcome without running again. A request that fails leaves no record,
+// since its transaction writes nothing, so the client may retry it.
+func idempotent(stub shim.ChaincodeStubInterface, function, requestID string, args []string, run func() ([]byte, error)) ([]byte, error) {
+	if len(requestID) > maxRequestIDLength {
+		return nil, newArgumentError(codeInvalidArgument, "requestId", "Request IDs are limited to %d bytes", maxRequestIDLength)
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	key, err := requestKey(stub, caller, requestID)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	height, err := getBlockHeight(stub)
+	if err != nil {
+		return nil, err
+	}
+	hash := argsHash(function, args)
+
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get request %s: %s", requestID, err)
+	}
+	if value != nil {
+		rec := &requestRecord{}
+		if err := json.Unmarshal(value, rec); err != nil {
+			return nil, fmt.Errorf("Corrupt request %s: %s", requestID, err)
+		}
+		expired, err := rec.expired(height, now)
+		if err != nil {
+			return nil, err
+		}
+		if !expired {
+			if rec.Function != function || rec.ArgsHash != hash {
+				return nil, newArgumentError(codeRequestIDReused, "requestId", "Request %s was already used for a different %s", requestID, rec.Function)
+			}
+			fmt.Printf("Request %s replayed, first run in %s\n", requestID, rec.TxID)
+			return value, nil
+		}
+	}
+
+	payload, err := run()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	rec := &requestRecord{
+		RequestID:      requestID,
+		Caller:         caller,
+		Function:       function,
+		ArgsHash:       hash,
+		TxID:           stub.GetTxID(),
+		ExpiresAtBlock: height + requestExpiryBlocks(cfg),
+		Result:         payload,
+	}
+	value, err = json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Removes every request record that has expired. Anyone may call it; an
+// expired record no longer answers replays either way.
+func (t *SimpleChaincode) expireRequests(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	height, err := getBlockHeight(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := stub.GetStateByPartialCompositeKey(requestObjectType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to scan requests: %s", err)
+	}
+	defer iter.Close()
+
+	var expired []string
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to scan requests: %s", err)
+		}
+		rec := &requestRecord{}
+		if err := json.Unmarshal(kv.Value, rec); err != nil {
+			return nil, fmt.Errorf("Corrupt request under %q: %s", kv.Key, err)
+		}
+		done, err := rec.expired(height, now)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			expired = append(expired, kv.Key)
+		}
+	}
+
+	for _, key := range expired {
+		if err := stub.DelState(key); err != nil {
+			return nil, err
+		}
+	}
+
+	err = setEvent(stub, eventRequestsExpired, &requestsExpiredEvent{
+		eventHeader: newEventHeader(stub),
+		Removed:     len(expired),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(expiredRequests{Removed: len(expired)})
+}
+
+// Records the block height of the channel, by which request IDs expire. Only
+// an admin may set it, typically from a block listener, and it never goes
+// back. Requests endorsed before the height changes and committed after it
+// fail validation, since they read it; the client retries them under the
+// same request ID.
+func (t *SimpleChaincode) setBlockHeight(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	height, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, newArgumentError(codeInvalidArgument, "height", "Invalid block height %q", args[0])
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireAdmin(stub, cfg, "Setting the block height")
+	if err != nil {
+		return nil, err
+	}
+	old, err := getBlockHeight(stub)
+	if err != nil {
+		return nil, err
+	}
+	if height <= old {
+		return nil, newArgumentError(codeInvalidArgument, "height", "Block height is already %d", old)
+	}
+	fmt.Printf("Block height %d\n", height)
+
+	key, err := heightKey(stub)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(&blockHeight{Height: height, UpdatedTx: stub.GetTxID()})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventBlockHeight, &blockHeightEvent{eventHeader: newEventHeader(stub), Height: height})
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// setHeight records block height h.
+func (f *fixture) setHeight(t *testing.T, h int) {
+	t.Helper()
+	checkOK(t, f.invoke(f.admin, "setBlockHeight", strconv.Itoa(h)))
+	f.checkEvent(t, eventBlockHeight)
+}
+
+func TestTransferRequestID(t *testing.T) {
+	f := newFixture(t, "A", "100", "B", "200", `{"requests":{"expiryBlocks":10}}`)
+	f.setHeight(t, 5)
+
+	first := f.invoke(f.admin, "transfer", "A", "B", "10", defaultCurrency, "r1")
+	checkOK(t, first)
+	var rec requestRecord
+	if err := json.Unmarshal(first.Payload, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.RequestID != "r1" || rec.Function != "transfer" || rec.TxID == "" || rec.ExpiresAtBlock != 15 {
+		t.Fatalf("unexpected request record %s", first.Payload)
+	}
+
+	// A retry is answered with the first outcome and moves nothing.
+	f.setHeight(t, 14)
+	replay := f.invoke(f.admin, "transfer", "A", "B", "10", defaultCurrency, "r1")
+	checkOK(t, replay)
+	if string(replay.Payload) != string(first.Payload) {
+		t.Fatalf("replay returned %s, expected %s", replay.Payload, first.Payload)
+	}
+	if f.lastEvent != nil {
+		t.Fatalf("replay emitted %s", f.lastEvent.EventName)
+	}
+	f.checkBalance(t, "A", "90")
+
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "11", defaultCurrency, "r1"), shim.ERRORTHRESHOLD, codeRequestIDReused)
+	checkFailed(t, f.invoke(f.admin, "batchTransfer", "A", `[{"to":"B","amount":"10"}]`, "r1"), shim.ERRORTHRESHOLD, codeRequestIDReused)
+
+	// IDs are scoped to the caller.
+	checkOK(t, f.invoke(f.admin, "transfer", "B", "A", "5"))
+	checkOK(t, f.invoke(f.alice, "create", "C"))
+	checkFailed(t, f.invoke(f.alice, "transfer", "C", "A", "1", defaultCurrency, "r1"), shim.ERRORTHRESHOLD, codeInsufficientFunds)
+
+	// Ten blocks later the ID is forgotten.
+	f.setHeight(t, 15)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "10", defaultCurrency, "r1"))
+	f.checkBalance(t, "A", "85")
+}
+
+func TestBatchTransferRequestID(t *testing.T) {
+	f := newFixture(t)
+	payments := `[{"to":"B","amount":"10"}]`
+	checkOK(t, f.invoke(f.admin, "batchTransfer", "A", payments, "r1"))
+	checkOK(t, f.invoke(f.admin, "batchTransfer", "A", payments, "r1"))
+	f.checkBalance(t, "A", "90")
+}
+
+func TestFailedRequestIsNotRecorded(t *testing.T) {
+	f := newFixture(t)
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "101", defaultCurrency, "r1"), shim.ERRORTHRESHOLD, codeInsufficientFunds)
+	checkOK(t, f.invoke(f.admin, "transfer", "B", "A", "1"))
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "101", defaultCurrency, "r1"))
+	f.checkBalance(t, "A", "0")
+}
+
+func TestExpireRequests(t *testing.T) {
+	f := newFixture(t, "A", "100", "B", "200", `{"requests":{"expiryBlocks":2}}`)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "1", defaultCurrency, "r1"))
+	f.setHeight(t, 1)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "1", defaultCurrency, "r2"))
+
+	f.setHeight(t, 2)
+	res := f.invoke(f.alice, "expireRequests")
+	checkOK(t, res)
+	if string(res.Payload) != `{"removed":1}` {
+		t.Fatalf("expected one expired request, got %s", res.Payload)
+	}
+	f.checkEvent(t, eventRequestsExpired)
+	key, _ := f.CreateCompositeKey(requestObjectType, []string{f.id(t, f.admin), "r2"})
+	if f.State[key] == nil {
+		t.Fatal("expireRequests removed an unexpired request")
+	}
+}
+
+func TestRequestOptions(t *testing.T) {
+	s := newTestStub()
+	admin := newIdentity(t, "Org1MSP", "admin")
+	checkFailed(t, s.init(admin, "A", "100", "B", "200", `{"requests":{}}`), shim.ERRORTHRESHOLD, "expiryBlocks must be a positive number")
+	checkFailed(t, s.init(admin, "A", "100", "B", "200", `{"requests":{"expiryBlocks":0}}`), shim.ERRORTHRESHOLD, "expiryBlocks must be a positive number")
+	checkFailed(t, s.init(admin, "A", "100", "B", "200", `{"requests":{"expiry":"10m"}}`), shim.ERRORTHRESHOLD, "Invalid Init options")
+}
+
+func TestSetBlockHeight(t *testing.T) {
+	f := newFixture(t)
+	checkFailed(t, f.invoke(f.alice, "setBlockHeight", "3"), shim.ERRORTHRESHOLD, codeUnauthorized)
+	f.setHeight(t, 3)
+	checkFailed(t, f.invoke(f.admin, "setBlockHeight", "3"), shim.ERRORTHRESHOLD, "already 3")
+	checkFailed(t, f.invoke(f.admin, "setBlockHeight", "0"), shim.ERRORTHRESHOLD, codeInvalidArgument)
+}
+
+func TestTimedRequestRecordsStillExpire(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "1", defaultCurrency, "r1"))
+	key, _ := f.CreateCompositeKey(requestObjectType, []string{f.id(t, f.admin), "r1"})
+	rec := &requestRecord{}
+	if err := json.Unmarshal(f.State[key], rec); err != nil {
+		t.Fatal(err)
+	}
+	rec.ExpiresAtBlock = 0
+	rec.ExpiresAt = f.now.Add(time.Hour).Format(time.RFC3339Nano)
+	value, _ := json.Marshal(rec)
+	f.MockTransactionStart("legacy")
+	f.MockStub.PutState(key, value)
+	f.MockTransactionEnd("legacy")
+
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "1", defaultCurrency, "r1"))
+	f.checkBalance(t, "A", "99")
+	f.now = f.now.Add(time.Hour)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "1", defaultCurrency, "r1"))
+	f.checkBalance(t, "A", "98")
+}
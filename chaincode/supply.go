@@ -0,0 +1,427 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const supplyObjectType = "supply"
+
+// supply is the total amount of an asset in existence: the sum of every
+// balance, plus what open escrows, locked HTLCs and pending transfers hold.
+type supply struct {
+	Asset     string `json:"asset"`
+	Total     Amount `json:"total"`
+	UpdatedTx string `json:"updatedTx"`
+}
+
+// supplyEvent is emitted by mint and burn.
+type supplyEvent struct {
+	eventHeader
+	Account string `json:"account"`
+	Asset   string `json:"asset"`
+	Amount  Amount `json:"amount"`
+	Supply  Amount `json:"supply"`
+}
+
+// supplyStub is handed to every function that can change the ledger. It adds
+// up how the transaction moves balances and supplies, so that the sum of
+// balances can be checked against the supply without reading every account,
+// and so that the change can be journaled.
+type supplyStub struct {
+	shim.ChaincodeStubInterface
+
+	// balances and supplies hold the net change of each asset.
+	balances map[string]Amount
+	supplies map[string]Amount
+	// changes holds the net change of each holder, and private the
+	// holders whose amounts stay off the journal.
+	changes map[legKey]Amount
+	private map[legKey]bool
+	// starts holds what each holder had before the transaction.
+	starts map[legKey]Amount
+	// memo describes the transaction in its journal entry.
+	memo string
+}
+
+func newSupplyStub(stub shim.ChaincodeStubInterface, memo string) *supplyStub {
+	return &supplyStub{
+		ChaincodeStubInterface: stub,
+		balances:               make(map[string]Amount),
+		supplies:               make(map[string]Amount),
+		changes:                make(map[legKey]Amount),
+		private:                make(map[legKey]bool),
+		starts:                 make(map[legKey]Amount),
+		memo:                   memo,
+	}
+}
+
+// trackBalance notes that an amount of symbol held by h changed from old to
+// new. Every write of a balance, escrow or HTLC must report itself. A holder
+// written twice counts once, from what it had before the transaction, since
+// the second write replaces the first.
+func trackBalance(stub shim.ChaincodeStubInterface, h holder, symbol string, old, new Amount) {
+	if s, ok := stub.(*supplyStub); ok {
+		key := legKey{kind: h.kind, id: h.id, asset: symbol}
+		if start, ok := s.starts[key]; ok {
+			old = start.Add(s.changes[key])
+		} else {
+			s.starts[key] = old
+		}
+		delta := new.Sub(old)
+		s.balances[symbol] = s.balances[symbol].Add(delta)
+		s.note(h, symbol, delta)
+	}
+}
+
+// finish checks the supply invariant and journals the transaction. Call it
+// once the handler has succeeded.
+func (s *supplyStub) finish() error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	return s.writeJournal()
+}
+
+// check fails unless every asset's balances changed by exactly as much as
+// its supply.
+func (s *supplyStub) check() error {
+	symbols := make([]string, 0, len(s.balances)+len(s.supplies))
+	for symbol := range s.balances {
+		symbols = append(symbols, symbol)
+	}
+	for symbol := range s.supplies {
+		if _, ok := s.balances[symbol]; !ok {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		if s.balances[symbol].Cmp(s.supplies[symbol]) != 0 {
+			return fmt.Errorf("Supply invariant violated for %s: balances changed by %s, supply by %s", symbol, s.balances[symbol], s.supplies[symbol])
+		}
+	}
+	return nil
+}
+
+func supplyKey(stub shim.ChaincodeStubInterface, symbol string) (string, error) {
+	return stub.CreateCompositeKey(supplyObjectType, []string{symbol})
+}
+
+// getSupply reads the supply record of symbol, or nil if there is none.
+func getSupply(stub shim.ChaincodeStubInterface, symbol string) (*supply, error) {
+	key, err := supplyKey(stub, symbol)
+	if err != nil {
+		return nil, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get supply of " + symbol)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	s := &supply{}
+	if err := json.Unmarshal(value, s); err != nil {
+		return nil, fmt.Errorf("Corrupt supply of %s: %s", symbol, err)
+	}
+	return s, nil
+}
+
+// adjustSupply adds delta to the supply of symbol. Call it at most once per
+// asset in a transaction, after totalling the change.
+func adjustSupply(stub shim.ChaincodeStubInterface, symbol string, delta Amount) (*supply, error) {
+	s, err := getSupply(stub, symbol)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		s = &supply{Asset: symbol}
+	}
+	if delta.Sign() == 0 {
+		return s, nil
+	}
+	return s, writeSupply(stub, s, delta)
+}
+
+// writeSupply adds delta to s and saves it. Only delta counts as a change
+// of supply; whatever s already held is taken as given.
+func writeSupply(stub shim.ChaincodeStubInterface, s *supply, delta Amount) error {
+	total := s.Total.Add(delta)
+	if total.Sign() < 0 {
+		return fmt.Errorf("Supply of %s cannot fall below zero", s.Asset)
+	}
+	s.Total = total
+	s.UpdatedTx = stub.GetTxID()
+
+	key, err := supplyKey(stub, s.Asset)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return err
+	}
+	if tracker, ok := stub.(*supplyStub); ok {
+		tracker.supplies[s.Asset] = tracker.supplies[s.Asset].Add(delta)
+		tracker.note(holder{kind: holderIssuance, id: s.Asset}, s.Asset, Amount{}.Sub(delta))
+	}
+	return nil
+}
+
+// countSupply totals the account currency held on the ledger: every account
+// balance and every open escrow, locked HTLC and pending transfer. Init uses
+// it once to start the supply record of deployments that predate it.
+func countSupply(stub shim.ChaincodeStubInterface) (Amount, error) {
+	total := Amount{}
+
+	iter, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return total, fmt.Errorf("Failed to count supply: %s", err)
+	}
+	defer iter.Close()
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return total, fmt.Errorf("Failed to count supply: %s", err)
+		}
+		if len(kv.Key) > 0 && kv.Key[0] == 0 {
+			continue // composite key
+		}
+		acct, err := decodeAccount(kv.Key, kv.Value)
+		if err != nil {
+			return total, err
+		}
+		if acct.Private != nil {
+			if err := loadPrivateBalance(stub, acct); err != nil {
+				return total, err
+			}
+		}
+		total = total.Add(acct.Balance)
+	}
+
+	escrows, err := stub.GetStateByPartialCompositeKey(escrowObjectType, []string{})
+	if err != nil {
+		return total, fmt.Errorf("Failed to count supply: %s", err)
+	}
+	defer escrows.Close()
+	for escrows.HasNext() {
+		kv, err := escrows.Next()
+		if err != nil {
+			return total, fmt.Errorf("Failed to count supply: %s", err)
+		}
+		e := &escrow{}
+		if err := json.Unmarshal(kv.Value, e); err != nil {
+			return total, fmt.Errorf("Corrupt escrow %q: %s", kv.Key, err)
+		}
+		if e.State == escrowOpen {
+			total = total.Add(e.Amount)
+		}
+	}
+
+	htlcs, err := stub.GetStateByPartialCompositeKey(htlcObjectType, []string{})
+	if err != nil {
+		return total, fmt.Errorf("Failed to count supply: %s", err)
+	}
+	defer htlcs.Close()
+	for htlcs.HasNext() {
+		kv, err := htlcs.Next()
+		if err != nil {
+			return total, fmt.Errorf("Failed to count supply: %s", err)
+		}
+		h := &htlc{}
+		if err := json.Unmarshal(kv.Value, h); err != nil {
+			return total, fmt.Errorf("Corrupt HTLC %q: %s", kv.Key, err)
+		}
+		if h.State == htlcLocked {
+			total = total.Add(h.Amount)
+		}
+	}
+
+	pending, err := stub.GetStateByPartialCompositeKey(approvalObjectType, []string{})
+	if err != nil {
+		return total, fmt.Errorf("Failed to count supply: %s", err)
+	}
+	defer pending.Close()
+	for pending.HasNext() {
+		kv, err := pending.Next()
+		if err != nil {
+			return total, fmt.Errorf("Failed to count supply: %s", err)
+		}
+		p := &pendingTransfer{}
+		if err := json.Unmarshal(kv.Value, p); err != nil {
+			return total, fmt.Errorf("Corrupt pending transfer %q: %s", kv.Key, err)
+		}
+		if p.State == approvalPending && p.Asset == defaultCurrency {
+			total = total.Add(p.Amount)
+		}
+	}
+	return total, nil
+}
+
+// requireIssuer fails with an authorization error unless the caller issues
+// a. Admins issue the account currency.
+func requireIssuer(stub shim.ChaincodeStubInterface, cfg *chaincodeConfig, a *asset) error {
+	if a.Issuer == "" {
+		return requireAdmin(stub, cfg, "Issuing "+a.Symbol)
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return err
+	}
+	if caller != a.Issuer {
+		return newCodedError(codeUnauthorized, "Only the issuer of %s may mint or burn it", a.Symbol)
+	}
+	return nil
+}
+
+// Creates new funds: account, amount and optionally the asset, which
+// defaults to the account currency. Only the issuer may mint.
+func (t *SimpleChaincode) mint(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return changeSupply(stub, args, eventMinted)
+}
+
+// Destroys funds: account, amount and optionally the asset. Only the issuer
+// may burn, and only from an account it owns.
+func (t *SimpleChaincode) burn(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return changeSupply(stub, args, eventBurned)
+}
+
+// changeSupply credits (eventMinted) or debits (eventBurned) an account and
+// its asset's supply alike.
+func changeSupply(stub shim.ChaincodeStubInterface, args []string, eventName string) ([]byte, error) {
+	A := args[0]
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	symbol := defaultCurrency
+	if len(args) > 2 {
+		symbol = args[2]
+	}
+	a, err := getAsset(stub, cfg, symbol)
+	if err != nil {
+		return nil, err
+	}
+	err = requireIssuer(stub, cfg, a)
+	if err != nil {
+		return nil, err
+	}
+	X, err := parseTransferAmount(args[1], a.Decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	acct, err := loadLiveAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	err = requireUnfrozen(acct)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := balanceOf(stub, acct, a)
+	if err != nil {
+		return nil, err
+	}
+	delta := X
+	if eventName == eventBurned {
+		err = requireOwner(stub, cfg, acct)
+		if err != nil {
+			return nil, err
+		}
+		available, err := availableBalance(stub, acct, a.Symbol, balance)
+		if err != nil {
+			return nil, err
+		}
+		if available.Cmp(X) < 0 {
+			return nil, newCodedError(codeInsufficientFunds, "%s has %s %s available, cannot burn %s", A, available, a.Symbol, X)
+		}
+		delta = Amount{}.Sub(X)
+	}
+	fmt.Printf("%s %s %s on %s\n", eventName, X, a.Symbol, A)
+
+	err = setBalance(stub, acct, a, balance.Add(delta))
+	if err != nil {
+		return nil, err
+	}
+	s, err := adjustSupply(stub, a.Symbol, delta)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventName, &supplyEvent{
+		eventHeader: newEventHeader(stub),
+		Account:     A,
+		Asset:       a.Symbol,
+		Amount:      X,
+		Supply:      s.Total,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Returns the supply record of an asset, by default the account currency,
+// with the transaction that last changed it.
+func (t *SimpleChaincode) supplyQuery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	symbol := defaultCurrency
+	if len(args) > 0 {
+		symbol = args[0]
+	}
+	a, err := getAsset(stub, cfg, symbol)
+	if err != nil {
+		return nil, err
+	}
+	s, err := getSupply(stub, a.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		s = &supply{Asset: a.Symbol, Total: Amount{}.quantize(a.Decimals)}
+	}
+	return json.Marshal(s)
+}
+
+// issueOpeningBalances adds the opening balances of new accounts to the
+// supply of the account currency.
+func issueOpeningBalances(stub shim.ChaincodeStubInterface, accts ...*account) error {
+	issued := Amount{}
+	for _, acct := range accts {
+		issued = issued.Add(acct.Balance)
+	}
+	if issued.Sign() == 0 {
+		return nil
+	}
+	_, err := adjustSupply(stub, defaultCurrency, issued)
+	return err
+}
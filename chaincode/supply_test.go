@@ -0,0 +1,145 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// checkSupply asserts the supply of symbol and returns its record.
+func (f *fixture) checkSupply(t *testing.T, symbol, want string) *supply {
+	t.Helper()
+	res := f.invoke(f.alice, "supply", symbol)
+	checkOK(t, res)
+	s := &supply{}
+	if err := json.Unmarshal(res.Payload, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Total.String() != want {
+		t.Fatalf("supply of %s is %s, expected %s", symbol, s.Total, want)
+	}
+	return s
+}
+
+func TestMintAndBurn(t *testing.T) {
+	f := newFixture(t)
+	if s := f.checkSupply(t, defaultCurrency, "300"); s.UpdatedTx != "tx1" {
+		t.Fatalf("supply last changed by %q, expected the Init transaction", s.UpdatedTx)
+	}
+
+	checkOK(t, f.invoke(f.admin, "mint", "A", "25"))
+	f.checkEvent(t, eventMinted)
+	f.checkBalance(t, "A", "125")
+	if s := f.checkSupply(t, defaultCurrency, "325"); s.UpdatedTx == "tx1" {
+		t.Fatalf("supply not stamped by mint: %+v", s)
+	}
+
+	checkOK(t, f.invoke(f.admin, "burn", "A", "5"))
+	f.checkBalance(t, "A", "120")
+	f.checkSupply(t, defaultCurrency, "320")
+
+	checkOK(t, f.invoke(f.alice, "create", "D"))
+	checkOK(t, f.invoke(f.admin, "mint", "D", "10"))
+	tests := []struct {
+		name    string
+		caller  *identity
+		args    []string
+		message string
+	}{
+		{name: "mint by non-issuer", caller: f.alice, args: []string{"mint", "D", "1"}, message: codeUnauthorized},
+		{name: "burn from another's account", caller: f.admin, args: []string{"burn", "D", "1"}, message: codeUnauthorized},
+		{name: "burn more than held", caller: f.admin, args: []string{"burn", "A", "121"}, message: codeInsufficientFunds},
+		{name: "zero", caller: f.admin, args: []string{"mint", "A", "0"}, message: codeZeroAmount},
+		{name: "unknown asset", caller: f.admin, args: []string{"mint", "A", "1", "SILVER"}, message: codeUnknownAsset},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkFailed(t, f.invoke(test.caller, test.args...), shim.ERRORTHRESHOLD, test.message)
+			f.checkSupply(t, defaultCurrency, "330")
+		})
+	}
+}
+
+func TestRegisteredAssetIssuer(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "registerAsset", "GOLD", "2", f.id(t, f.alice)))
+	f.checkSupply(t, "GOLD", "0.00")
+	checkFailed(t, f.invoke(f.admin, "mint", "B", "1", "GOLD"), shim.ERRORTHRESHOLD, codeUnauthorized)
+	checkOK(t, f.invoke(f.alice, "mint", "B", "3", "GOLD"))
+	f.checkSupply(t, "GOLD", "3.00")
+	f.checkSupply(t, defaultCurrency, "300")
+}
+
+func TestSupplyFollowsEveryMutation(t *testing.T) {
+	f := newFixture(t)
+	later := f.now.Add(time.Hour).Format(time.RFC3339)
+
+	checkOK(t, f.invoke(f.admin, "create", "C", "50"))
+	f.checkSupply(t, defaultCurrency, "350")
+	checkOK(t, f.invoke(f.admin, "createBatch", `[{"id":"D","balance":"7"},{"id":"E"}]`))
+	f.checkSupply(t, defaultCurrency, "357")
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "10"))
+	checkOK(t, f.invoke(f.admin, "escrowOpen", "e1", "A", "B", "arb", "20", later))
+	checkOK(t, f.invoke(f.admin, "htlcLock", "h1", "A", "B", "30", hashOf(t, testPreimage), later))
+	f.checkSupply(t, defaultCurrency, "357")
+
+	// A deleted account keeps its funds in circulation.
+	checkOK(t, f.invoke(f.admin, "delete", "A"))
+	f.checkSupply(t, defaultCurrency, "357")
+	checkOK(t, f.invoke(f.alice, "htlcClaim", "h1", testPreimage))
+	f.checkBalance(t, "B", "240")
+	f.checkSupply(t, defaultCurrency, "357")
+}
+
+func TestInitCountsExistingAccounts(t *testing.T) {
+	f := newFixture(t)
+	f.MockTransactionStart("legacy")
+	f.MockStub.PutState("L", []byte("50"))
+	supplyKey, _ := f.CreateCompositeKey(supplyObjectType, []string{defaultCurrency})
+	f.MockStub.DelState(supplyKey)
+	f.MockTransactionEnd("legacy")
+
+	// Re-running Init, as an upgrade does, keeps A and B and counts L.
+	checkOK(t, f.init(f.admin, "A", "10", "B", "20"))
+	f.checkSupply(t, defaultCurrency, "350")
+	checkOK(t, f.init(f.admin, "A", "10", "B", "20", `{"migrate":true}`))
+	f.checkSupply(t, defaultCurrency, "80")
+	checkOK(t, f.init(f.admin, "A", "15", "B", "20", `{"migrate":true}`))
+	f.checkSupply(t, defaultCurrency, "85")
+}
+
+func TestSupplyInvariant(t *testing.T) {
+	s := newSupplyStub(newTestStub(), "test")
+	trackBalance(s, holder{kind: holderAccount, id: "A"}, defaultCurrency, Amount{}, Amount{units: bigTen})
+	if err := s.check(); err == nil {
+		t.Fatal("expected balances created without a supply change to be refused")
+	}
+	s.supplies[defaultCurrency] = Amount{units: bigTen}
+	if err := s.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing A again replaces its balance rather than adding to it.
+	trackBalance(s, holder{kind: holderAccount, id: "A"}, defaultCurrency, Amount{}, Amount{units: bigTen})
+	if err := s.check(); err != nil {
+		t.Fatal(err)
+	}
+}
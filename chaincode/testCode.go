@@ -20,49 +20,154 @@ package main
 //calling chaincode from a chaincode. If this example is modified, chaincode_example04.go has
 //to be modified as well with the new ID of chaincode_example02.
 //chaincode_example05 show's how chaincode ID can be passed in as a parameter instead of
-//hard-coding.
+//hard-coding. This chaincode calls out the second way: the KYC registry it consults before
+//a payment is named in its Init options, see kyc.go.
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
-	"strconv"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
 // SimpleChaincode example simple Chaincode implementation
 type SimpleChaincode struct {
 }
 
-func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	var A, B string    // Entities
-	var Aval, Bval int // Asset holdings
+// Init seeds the ledger with two entities: A, Aval, B, Bval [, options]
+func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	tracked := newSupplyStub(stub, "init")
+	payload, err := t.init(tracked, args)
+	if err == nil {
+		err = tracked.finish()
+	}
+	return respond(payload, err)
+}
+
+func (t *SimpleChaincode) init(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var A, B string       // Entities
+	var Aval, Bval Amount // Asset holdings
 	var err error
 
-	if len(args) != 4 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	if len(args) != 4 && len(args) != 5 {
+		return nil, newCodedError(codeBadArity, "Incorrect number of arguments. Expecting 4, optionally followed by JSON options")
+	}
+
+	opts := &initOptions{}
+	if len(args) == 5 {
+		opts, err = parseConfig(args[4])
+		if err != nil {
+			return nil, err
+		}
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Re-running Init, as a chaincode upgrade does, keeps the settings an
+	// earlier deployment stored unless the options ask to migrate them.
+	cfg := opts.chaincodeConfig
+	stored, err := getStoredConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case stored != nil && opts.Migrate:
+		if !stored.isAdmin(caller) {
+			return nil, newCodedError(codeUnauthorized, "Migrating the chaincode configuration may only be done by an admin")
+		}
+	case stored != nil && cfg != nil:
+		return nil, newArgumentError(codeInvalidArgument, "options", "The chaincode is already initialized; set \"migrate\":true to replace its settings")
+	case stored != nil:
+		cfg = stored
+	case cfg == nil:
+		cfg = defaultConfig()
+	}
+	if len(cfg.Admins) == 0 {
+		cfg.Admins = []string{caller}
 	}
 
 	// Initialize the chaincode
 	A = args[0]
-	Aval, err = strconv.Atoi(args[1])
+	if err := validateAccountID(A); err != nil {
+		return nil, err
+	}
+	Aval, err = parseHolding(args[1], cfg.Scale)
 	if err != nil {
-		return nil, errors.New("Expecting integer value for asset holding")
+		return nil, err
 	}
 	B = args[2]
-	Bval, err = strconv.Atoi(args[3])
+	if err := validateAccountID(B); err != nil {
+		return nil, err
+	}
+	if B == A {
+		return nil, newArgumentError(codeInvalidArgument, "B", "Init needs two different accounts, got %s twice", A)
+	}
+	Bval, err = parseHolding(args[3], cfg.Scale)
 	if err != nil {
-		return nil, errors.New("Expecting integer value for asset holding")
+		return nil, err
+	}
+	fmt.Printf("Aval = %s, Bval = %s\n", Aval, Bval)
+
+	// Start the supply record from what an earlier deployment left on the
+	// ledger. Accounts of the same name are kept, or replaced when the
+	// options ask to migrate.
+	issued := Amount{}.quantize(cfg.Scale)
+	total, err := getSupply(stub, defaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+	if total == nil {
+		counted, err := countSupply(stub)
+		if err != nil {
+			return nil, err
+		}
+		total = &supply{Asset: defaultCurrency, Total: counted}
+	}
+	var opened []*account
+	for _, acct := range []*account{newAccount(stub, A, caller, Aval), newAccount(stub, B, caller, Bval)} {
+		old, err := getPublicAccount(stub, acct.ID)
+		if err != nil {
+			return nil, err
+		}
+		if old != nil && !opts.Migrate {
+			fmt.Printf("Keeping existing account %s\n", acct.ID)
+			continue
+		}
+		if old != nil {
+			old, err = getAccount(stub, acct.ID)
+			if err != nil {
+				return nil, err
+			}
+			acct.loaded = old.Balance
+			issued = issued.Sub(old.Balance)
+		}
+		issued = issued.Add(acct.Balance)
+		opened = append(opened, acct)
 	}
-	fmt.Printf("Aval = %d, Bval = %d\n", Aval, Bval)
 
 	// Write the state to the ledger
-	err = stub.PutState(A, []byte(strconv.Itoa(Aval)))
+	err = putConfig(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, acct := range opened {
+		err = putAccount(stub, acct)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = writeSupply(stub, total, issued)
 	if err != nil {
 		return nil, err
 	}
 
-	err = stub.PutState(B, []byte(strconv.Itoa(Bval)))
+	err = setEvent(stub, eventAccountsCreated, newAccountsCreatedEvent(stub, opened...))
 	if err != nil {
 		return nil, err
 	}
@@ -70,67 +175,393 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	return nil, nil
 }
 
-// Transaction makes payment of X units from A to B
-func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	if len(args) != 3 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 4")
-		fmt.Println("Error: para is not 3")
-		log.fatal("Error: param is not 3")
-	}
-	
-	if function == "delete" {
-		// Deletes an entity from its state
-		return t.delete(stub, args)
-	}
+// invokeFunctions lists every function Invoke accepts.
+var invokeFunctions = registry{
+	"transfer": {
+		args:     []argSpec{{"from", argName}, {"to", argName}, {"amount", argAmount}, {"asset", argName}, {"requestId", argName}},
+		optional: 2,
+		handler:  (*SimpleChaincode).transfer,
+	},
+	// invoke is the payment verb of clients written against chaincode_example02.
+	"invoke": {
+		args:    []argSpec{{"from", argName}, {"to", argName}, {"amount", argAmount}},
+		handler: (*SimpleChaincode).transfer,
+	},
+	"batchTransfer": {
+		args:     []argSpec{{"from", argName}, {"payments", argJSON}, {"requestId", argName}},
+		optional: 1,
+		handler:  (*SimpleChaincode).batchTransfer,
+	},
+	"escrowOpen": {
+		args: []argSpec{
+			{"id", argName}, {"payer", argName}, {"beneficiary", argName},
+			{"arbiter", argName}, {"amount", argAmount}, {"expiry", argTime},
+		},
+		handler: (*SimpleChaincode).escrowOpen,
+	},
+	"escrowRelease": {
+		args:    []argSpec{{"id", argName}},
+		handler: (*SimpleChaincode).escrowRelease,
+	},
+	"escrowRefund": {
+		args:    []argSpec{{"id", argName}},
+		handler: (*SimpleChaincode).escrowRefund,
+	},
+	"escrowExpire": {
+		args:    []argSpec{{"id", argName}},
+		handler: (*SimpleChaincode).escrowExpire,
+	},
+	"expireRequests": {
+		handler: (*SimpleChaincode).expireRequests,
+	},
+	"setBlockHeight": {
+		args:    []argSpec{{"height", argCount}},
+		handler: (*SimpleChaincode).setBlockHeight,
+	},
+	"approveTransfer": {
+		args:    []argSpec{{"id", argName}},
+		handler: (*SimpleChaincode).approveTransfer,
+	},
+	"rejectTransfer": {
+		args:    []argSpec{{"id", argName}},
+		handler: (*SimpleChaincode).rejectTransfer,
+	},
+	"expireTransfer": {
+		args:    []argSpec{{"id", argName}},
+		handler: (*SimpleChaincode).expireTransfer,
+	},
+	"setApprovalThreshold": {
+		args:    []argSpec{{"threshold", argAmount}},
+		handler: (*SimpleChaincode).setApprovalThreshold,
+	},
+	"freeze": {
+		args:    []argSpec{{"entity", argName}, {"reason", argName}},
+		handler: (*SimpleChaincode).freeze,
+	},
+	"unfreeze": {
+		args:    []argSpec{{"entity", argName}, {"reason", argName}},
+		handler: (*SimpleChaincode).unfreeze,
+	},
+	"hold": {
+		args:     []argSpec{{"entity", argName}, {"holdId", argName}, {"amount", argAmount}, {"reason", argName}, {"asset", argName}},
+		optional: 1,
+		handler:  (*SimpleChaincode).hold,
+	},
+	"releaseHold": {
+		args:    []argSpec{{"entity", argName}, {"holdId", argName}, {"reason", argName}},
+		handler: (*SimpleChaincode).releaseHold,
+	},
+	"htlcLock": {
+		args: []argSpec{
+			{"id", argName}, {"sender", argName}, {"recipient", argName},
+			{"amount", argAmount}, {"hashlock", argHash}, {"timelock", argTime},
+		},
+		handler: (*SimpleChaincode).htlcLock,
+	},
+	"htlcClaim": {
+		args:    []argSpec{{"id", argName}, {"preimage", argHex}},
+		handler: (*SimpleChaincode).htlcClaim,
+	},
+	"htlcRefund": {
+		args:    []argSpec{{"id", argName}},
+		handler: (*SimpleChaincode).htlcRefund,
+	},
+	"delete": {
+		args:    []argSpec{{"entity", argName}},
+		handler: (*SimpleChaincode).delete,
+	},
+	"restore": {
+		args:    []argSpec{{"entity", argName}},
+		handler: (*SimpleChaincode).restore,
+	},
+	"setPolicy": {
+		args:    []argSpec{{"entity", argName}, {"policy", argJSON}},
+		handler: (*SimpleChaincode).setPolicy,
+	},
+	"setSchedule": {
+		args:    []argSpec{{"class", argName}, {"schedule", argJSON}},
+		handler: (*SimpleChaincode).setSchedule,
+	},
+	"setClass": {
+		args:    []argSpec{{"entity", argName}, {"class", argName}},
+		handler: (*SimpleChaincode).setClass,
+	},
+	"accrue": {
+		args:    []argSpec{{"entity", argName}},
+		handler: (*SimpleChaincode).accrue,
+	},
+	"create": {
+		args:     []argSpec{{"entity", argName}, {"balance", argAmount}},
+		optional: 1,
+		handler:  (*SimpleChaincode).create,
+	},
+	"createBatch": {
+		args:    []argSpec{{"accounts", argJSON}},
+		handler: (*SimpleChaincode).createBatch,
+	},
+	"createPrivate": {
+		args:    []argSpec{{"entity", argName}, {"collection", argName}, {"members", argJSON}},
+		handler: (*SimpleChaincode).createPrivate,
+	},
+	"registerAsset": {
+		args:    []argSpec{{"symbol", argName}, {"decimals", argName}, {"issuer", argName}},
+		handler: (*SimpleChaincode).registerAsset,
+	},
+	"mint": {
+		args:     []argSpec{{"account", argName}, {"amount", argAmount}, {"asset", argName}},
+		optional: 1,
+		handler:  (*SimpleChaincode).mint,
+	},
+	"burn": {
+		args:     []argSpec{{"account", argName}, {"amount", argAmount}, {"asset", argName}},
+		optional: 1,
+		handler:  (*SimpleChaincode).burn,
+	},
+
+	// Read-only functions, the Query entry point of pre-1.0 chaincode.
+	"query": {
+		readOnly: true,
+		args:     []argSpec{{"entity", argName}, {"asset", argName}},
+		optional: 1,
+		handler:  (*SimpleChaincode).query,
+	},
+	"queryMany": {
+		readOnly: true,
+		args:     []argSpec{{"entities", argJSON}, {"asset", argName}},
+		optional: 1,
+		handler:  (*SimpleChaincode).queryMany,
+	},
+	"list": {
+		readOnly: true,
+		args:     []argSpec{{"pageSize", argCount}, {"bookmark", argName}},
+		optional: 2,
+		handler:  (*SimpleChaincode).list,
+	},
+	"history": {
+		readOnly: true,
+		args:     []argSpec{{"entity", argName}},
+		handler:  (*SimpleChaincode).history,
+	},
+	"prefix": {
+		readOnly: true,
+		args:     []argSpec{{"index", argName}, {"attribute", argName}},
+		optional: 1,
+		variadic: true,
+		handler:  (*SimpleChaincode).prefix,
+	},
+	"escrow": {
+		readOnly: true,
+		args:     []argSpec{{"id", argName}},
+		handler:  (*SimpleChaincode).escrowQuery,
+	},
+	"htlc": {
+		readOnly: true,
+		args:     []argSpec{{"id", argName}},
+		handler:  (*SimpleChaincode).htlcQuery,
+	},
+	"holds": {
+		readOnly: true,
+		args:     []argSpec{{"entity", argName}},
+		handler:  (*SimpleChaincode).holdsQuery,
+	},
+	"pendingTransfer": {
+		readOnly: true,
+		args:     []argSpec{{"id", argName}},
+		handler:  (*SimpleChaincode).pendingTransferQuery,
+	},
+	"pendingApprovals": {
+		readOnly: true,
+		args:     []argSpec{{"approver", argName}},
+		optional: 1,
+		handler:  (*SimpleChaincode).pendingApprovals,
+	},
+	"asset": {
+		readOnly: true,
+		args:     []argSpec{{"symbol", argName}},
+		handler:  (*SimpleChaincode).assetQuery,
+	},
+	"supply": {
+		readOnly: true,
+		args:     []argSpec{{"asset", argName}},
+		optional: 1,
+		handler:  (*SimpleChaincode).supplyQuery,
+	},
+	"policy": {
+		readOnly: true,
+		args:     []argSpec{{"entity", argName}},
+		handler:  (*SimpleChaincode).policyQuery,
+	},
+	"schedule": {
+		readOnly: true,
+		args:     []argSpec{{"class", argName}},
+		handler:  (*SimpleChaincode).scheduleQuery,
+	},
+	"postings": {
+		readOnly: true,
+		args:     []argSpec{{"entity", argName}},
+		handler:  (*SimpleChaincode).postingsQuery,
+	},
+	"journal": {
+		readOnly: true,
+		args:     []argSpec{{"entity", argName}, {"asset", argName}},
+		optional: 1,
+		handler:  (*SimpleChaincode).journal,
+	},
+	"whoami": {
+		readOnly: true,
+		handler:  (*SimpleChaincode).whoami,
+	},
+}
 
-	var A, B string    // Entities
-	var Aval, Bval int // Asset holdings
-	var X int          // Transaction value
-	var err error
+// Invoke runs the function named by the first argument
+func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	return respond(invokeFunctions.dispatch(t, stub, function, args))
+}
 
-	if len(args) != 3 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+// Transaction makes payment of X units from A to B, in the account currency
+// unless an asset is named. A payment tagged with a request ID is made only
+// once however often it is submitted. A payment above the approval threshold
+// is held until an approver checks it, and the pending transfer is returned.
+// When either account is private the amount is passed in the transient map
+// and kept out of the event and the journal.
+func (t *SimpleChaincode) transfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 4 {
+		return idempotent(stub, "transfer", args[4], args[:4], func() ([]byte, error) {
+			return t.transfer(stub, args[:4])
+		})
 	}
 
+	var A, B string // Entities
+	var X Amount    // Transaction value
+	var err error
+
 	A = args[0]
 	B = args[1]
+	if A == B {
+		return nil, newCodedError(codeSelfTransfer, "Cannot transfer from %s to itself", A)
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	symbol := defaultCurrency
+	if len(args) > 3 {
+		symbol = args[3]
+	}
+	a, err := getAsset(stub, cfg, symbol)
+	if err != nil {
+		return nil, withArgument(err, "asset")
+	}
 
 	// Get the state from the ledger
-	// TODO: will be nice to have a GetAllState call to ledger
-	Avalbytes, err := stub.GetState(A)
+	Aacct, err := getAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	if Aacct == nil {
+		return nil, newArgumentError(codeEntityNotFound, "from", "Entity %s not found", A)
+	}
+	err = requireLive(Aacct)
 	if err != nil {
-		return nil, errors.New("Failed to get state")
+		return nil, err
 	}
-	if Avalbytes == nil {
-		return nil, errors.New("Entity not found")
+	err = requireUnfrozen(Aacct)
+	if err != nil {
+		return nil, err
+	}
+	err = requireOwner(stub, cfg, Aacct)
+	if err != nil {
+		return nil, err
 	}
-	Aval, _ = strconv.Atoi(string(Avalbytes))
 
-	Bvalbytes, err := stub.GetState(B)
+	Bacct, err := getAccount(stub, B)
 	if err != nil {
-		return nil, errors.New("Failed to get state")
+		return nil, err
 	}
-	if Bvalbytes == nil {
-		return nil, errors.New("Entity not found")
+	if Bacct == nil {
+		return nil, newArgumentError(codeEntityNotFound, "to", "Entity %s not found", B)
+	}
+	err = requireLive(Bacct)
+	if err != nil {
+		return nil, withArgument(err, "to")
+	}
+	err = requireUnfrozen(Bacct)
+	if err != nil {
+		return nil, withArgument(err, "to")
+	}
+
+	Aval, err := balanceOf(stub, Aacct, a)
+	if err != nil {
+		return nil, err
+	}
+	Bval, err := balanceOf(stub, Bacct, a)
+	if err != nil {
+		return nil, err
 	}
-	Bval, _ = strconv.Atoi(string(Bvalbytes))
 
 	// Perform the execution
-	X, err = strconv.Atoi(args[2])
+	private := Aacct.Private != nil || Bacct.Private != nil
+	if private {
+		X, err = privateAmount(stub, args[2], a.Decimals)
+	} else {
+		X, err = parseTransferAmount(args[2], a.Decimals)
+	}
 	if err != nil {
-		return nil, errors.New("Invalid transaction amount, expecting a integer value")
+		return nil, withArgument(err, "amount")
+	}
+	available, err := availableBalance(stub, Aacct, a.Symbol, Aval)
+	if err != nil {
+		return nil, err
 	}
-	Aval = Aval - X
-	Bval = Bval + X
-	fmt.Printf("Aval = %d, Bval = %d\n", Aval, Bval)
+	if available.Cmp(X) < 0 {
+		return nil, newCodedError(codeInsufficientFunds, "%s has %s %s available, cannot transfer %s", A, available, a.Symbol, X)
+	}
+	err = requireKYC(stub, cfg, A, B)
+	if err != nil {
+		return nil, err
+	}
+	err = enforcePolicy(stub, Aacct, a, debit{to: B, amount: X})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.needsApproval(a.Symbol, X) {
+		if private {
+			// A pending transfer records its amount on the ledger.
+			return nil, newCodedError(codeApprovalRequired, "Transfers with a private account cannot wait for approval")
+		}
+		return holdTransfer(stub, cfg, Aacct, B, a, Aval.Sub(X), X)
+	}
+	Aval = Aval.Sub(X)
+	Bval = Bval.Add(X)
+	fmt.Printf("Aval = %s, Bval = %s %s\n", Aval, Bval, a.Symbol)
 
 	// Write the state back to the ledger
-	err = stub.PutState(A, []byte(strconv.Itoa(Aval)))
+	err = setBalance(stub, Aacct, a, Aval)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setBalance(stub, Bacct, a, Bval)
 	if err != nil {
 		return nil, err
 	}
 
-	err = stub.PutState(B, []byte(strconv.Itoa(Bval)))
+	event := &transferEvent{
+		eventHeader: newEventHeader(stub),
+		From:        A,
+		To:          B,
+		Asset:       a.Symbol,
+		Private:     private,
+	}
+	if private {
+		hideAmount(stub, accountHolder(Aacct), a.Symbol)
+		hideAmount(stub, accountHolder(Bacct), a.Symbol)
+	} else {
+		event.Amount = &X
+	}
+	err = setEvent(stub, eventTransfer, event)
 	if err != nil {
 		return nil, err
 	}
@@ -138,52 +569,231 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function stri
 	return nil, nil
 }
 
-// Deletes an entity from state
+// Deletes an entity by tombstoning it. Its balances stay on the ledger,
+// frozen, until an admin restores it. Only the public document is read and
+// written, so the balance of a private account stays in its collection and
+// out of the tombstone.
 func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	A := args[0]
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireAdmin(stub, cfg, "Deleting an account")
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
 	}
 
+	acct, err := getPublicAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, newArgumentError(codeEntityNotFound, "entity", "Entity %s not found", A)
+	}
+	if acct.Tombstone != nil {
+		return nil, newCodedError(codeInvalidState, "Account %s is already deleted", A)
+	}
+	held, err := listHoldings(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tombstone the account in the ledger
+	acct.Tombstone = &tombstone{
+		FinalBalance: publicBalance(acct),
+		DeletedBy:    caller,
+		DeletedTx:    stub.GetTxID(),
+	}
+	err = putPublicAccount(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventAccountDeleted, &accountDeletedEvent{
+		eventHeader: newEventHeader(stub),
+		ID:          A,
+		Balance:     publicBalance(acct),
+		Holdings:    held,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Brings a deleted entity back with the balances it was deleted with
+func (t *SimpleChaincode) restore(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	A := args[0]
 
-	// Delete the key from the state in ledger
-	err := stub.DelState(A)
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = requireAdmin(stub, cfg, "Restoring an account")
 	if err != nil {
-		return nil, errors.New("Failed to delete state")
+		return nil, err
+	}
+
+	acct, err := loadPublicAccount(stub, A)
+	if err != nil {
+		return nil, err
+	}
+	if acct.Tombstone == nil {
+		return nil, newCodedError(codeInvalidState, "Account %s is not deleted", A)
+	}
+	held, err := listHoldings(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Restored %s, deleted in %s\n", A, acct.Tombstone.DeletedTx)
+
+	acct.Tombstone = nil
+	err = putPublicAccount(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventAccountRestored, &accountRestoredEvent{
+		eventHeader: newEventHeader(stub),
+		ID:          A,
+		Balance:     publicBalance(acct),
+		Holdings:    held,
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return nil, nil
 }
 
-// Query callback representing the query of a chaincode
-func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	if function != "query" {
-		return nil, errors.New("Invalid query function name. Expecting \"query\"")
+// Opens a new account, optionally with an opening balance
+func (t *SimpleChaincode) create(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	spec := accountSpec{ID: args[0]}
+	if len(args) > 1 {
+		spec.Balance = args[1]
 	}
-	var A string // Entities
-	var err error
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the person to query")
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	acct, err := openAccount(stub, cfg, caller, spec)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Created %s with %s\n", acct.ID, acct.Balance)
+
+	err = putAccount(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+	err = issueOpeningBalances(stub, acct)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, eventAccountsCreated, newAccountsCreatedEvent(stub, acct))
+	if err != nil {
+		return nil, err
 	}
 
+	return nil, nil
+}
+
+// Opens every account of a JSON array in one transaction. Nothing is written
+// unless every entry is valid and new.
+func (t *SimpleChaincode) createBatch(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var specs []accountSpec
+	if err := decodeStrict(args[0], &specs); err != nil {
+		return nil, newArgumentError(codeInvalidArgument, "accounts", "Invalid account list: %s", err)
+	}
+	if len(specs) == 0 {
+		return nil, newArgumentError(codeInvalidArgument, "accounts", "Invalid account list: no accounts given")
+	}
+
+	cfg, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(specs))
+	accts := make([]*account, 0, len(specs))
+	for _, spec := range specs {
+		if seen[spec.ID] {
+			return nil, newCodedError(codeAccountExists, "Account %s is listed more than once", spec.ID)
+		}
+		seen[spec.ID] = true
+
+		acct, err := openAccount(stub, cfg, caller, spec)
+		if err != nil {
+			return nil, err
+		}
+		accts = append(accts, acct)
+	}
+
+	for _, acct := range accts {
+		err = putAccount(stub, acct)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = issueOpeningBalances(stub, accts...)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Created %d accounts\n", len(accts))
+
+	err = setEvent(stub, eventAccountsCreated, newAccountsCreatedEvent(stub, accts...))
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Returns the balance held by an entity, with its owner and the
+// transaction that last changed it
+func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var A string // Entities
+	var err error
+
 	A = args[0]
 
+	var a *asset
+	if len(args) > 1 {
+		a, err = queryAsset(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Get the state from the ledger
-	Avalbytes, err := stub.GetState(A)
+	doc, err := readBalance(stub, A, a)
 	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + A + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
 	}
 
-	if Avalbytes == nil {
-		jsonResp := "{\"Error\":\"Nil amount for " + A + "\"}"
-		return nil, errors.New(jsonResp)
+	jsonResp, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
 	}
-
-	jsonResp := "{\"Name\":\"" + A + "\",\"Amount\":\"" + string(Avalbytes) + "\"}"
 	fmt.Printf("Query Response:%s\n", jsonResp)
-	return Avalbytes, nil
+	return jsonResp, nil
 }
 
 func main() {
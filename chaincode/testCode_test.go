@@ -0,0 +1,489 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// fixture is a chaincode initialized with A=100 and B=200, both owned by
+// admin. alice is an unrelated client.
+type fixture struct {
+	*testStub
+	admin *identity
+	alice *identity
+}
+
+// testNow is the transaction time a fixture starts at.
+var testNow = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newFixture(t *testing.T, initArgs ...string) *fixture {
+	f := &fixture{
+		testStub: newTestStub(),
+		admin:    newIdentity(t, "Org1MSP", "admin"),
+		alice:    newIdentity(t, "Org1MSP", "alice"),
+	}
+	f.now = testNow
+	if len(initArgs) == 0 {
+		initArgs = []string{"A", "100", "B", "200"}
+	}
+	checkOK(t, f.init(f.admin, initArgs...))
+	return f
+}
+
+// checkEvent asserts that the last invocation emitted name.
+func (f *fixture) checkEvent(t *testing.T, name string) {
+	t.Helper()
+	if f.lastEvent == nil || f.lastEvent.EventName != name {
+		t.Fatalf("expected a %s event, got %v", name, f.lastEvent)
+	}
+}
+
+func checkOK(t *testing.T, res pb.Response) {
+	t.Helper()
+	if res.Status != shim.OK {
+		t.Fatalf("expected success, got %d: %s", res.Status, res.Message)
+	}
+}
+
+func checkFailed(t *testing.T, res pb.Response, status int32, message string) {
+	t.Helper()
+	if res.Status != status {
+		t.Fatalf("expected status %d, got %d: %s", status, res.Status, res.Message)
+	}
+	if !strings.Contains(res.Message, message) {
+		t.Fatalf("expected message containing %q, got %q", message, res.Message)
+	}
+}
+
+// checkBalance queries id and compares its balance with want.
+func (f *fixture) checkBalance(t *testing.T, id, want string) {
+	t.Helper()
+	res := f.invoke(f.alice, "query", id)
+	if res.Status != shim.OK {
+		t.Fatalf("query %s: %s", id, res.Message)
+	}
+	var doc balanceDocument
+	if err := json.Unmarshal(res.Payload, &doc); err != nil {
+		t.Fatalf("query %s: %s", id, err)
+	}
+	if got := doc.Amount.String(); got != want {
+		t.Fatalf("balance of %s is %s, expected %s", id, got, want)
+	}
+}
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		status  int32
+		message string
+		a, b    string
+	}{
+		{name: "integers", args: []string{"A", "100", "B", "200"}, a: "100", b: "200"},
+		{name: "scale option", args: []string{"A", "100", "B", "0.125", `{"scale":2}`}, a: "100.00", b: "0.12"},
+		{name: "too few args", args: []string{"A", "100", "B"}, status: shim.ERRORTHRESHOLD, message: codeBadArity},
+		{name: "too many args", args: []string{"A", "100", "B", "200", "{}", "x"}, status: shim.ERRORTHRESHOLD, message: codeBadArity},
+		{name: "non-decimal holding", args: []string{"A", "lots", "B", "200"}, status: shim.ERRORTHRESHOLD, message: codeInvalidAmount},
+		{name: "negative holding", args: []string{"A", "100", "B", "-1"}, status: shim.ERRORTHRESHOLD, message: codeNegativeAmount},
+		{name: "same account twice", args: []string{"A", "10", "A", "20"}, status: shim.ERRORTHRESHOLD, message: "two different accounts"},
+		{name: "unknown option", args: []string{"A", "100", "B", "200", `{"colour":"red"}`}, status: shim.ERRORTHRESHOLD, message: "Invalid Init options"},
+		{name: "trailing brace", args: []string{"A", "100", "B", "200", `{"scale":2}}`}, status: shim.ERRORTHRESHOLD, message: "unexpected data after JSON document"},
+		{name: "scale out of range", args: []string{"A", "100", "B", "200", `{"scale":-1}`}, status: shim.ERRORTHRESHOLD, message: "scale must be between"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestStub()
+			admin := newIdentity(t, "Org1MSP", "admin")
+			res := s.init(admin, test.args...)
+			if test.status != 0 {
+				checkFailed(t, res, test.status, test.message)
+				return
+			}
+			checkOK(t, res)
+			f := &fixture{testStub: s, admin: admin, alice: admin}
+			f.checkBalance(t, "A", test.a)
+			f.checkBalance(t, "B", test.b)
+		})
+	}
+}
+
+func TestReinitKeepsDeployment(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "10"))
+	checkOK(t, f.invoke(f.admin, "freeze", "A", "SANCTIONS"))
+
+	// An upgrade run by someone else keeps the admins and A, and opens C.
+	checkOK(t, f.init(f.alice, "A", "5", "C", "7"))
+	f.checkBalance(t, "A", "90")
+	f.checkBalance(t, "C", "7")
+	f.checkSupply(t, defaultCurrency, "307")
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "1"), shim.ERRORTHRESHOLD, codeAccountFrozen)
+	checkFailed(t, f.invoke(f.alice, "mint", "C", "1"), shim.ERRORTHRESHOLD, codeUnauthorized)
+
+	checkFailed(t, f.init(f.admin, "A", "5", "B", "5", `{"scale":2}`), shim.ERRORTHRESHOLD, "already initialized")
+	checkFailed(t, f.init(f.alice, "A", "5", "B", "5", `{"migrate":true}`), shim.ERRORTHRESHOLD, codeUnauthorized)
+	checkFailed(t, f.init(f.admin, "\x00A", "5", "B", "5"), shim.ERRORTHRESHOLD, "Invalid account name")
+
+	// Only an explicit migration resets them.
+	checkOK(t, f.init(f.admin, "A", "5", "B", "5", `{"migrate":true}`))
+	f.checkBalance(t, "A", "5")
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "1"))
+}
+
+func TestInvoke(t *testing.T) {
+	tests := []struct {
+		name     string
+		asAlice  bool
+		args     []string
+		status   int32
+		message  string
+		balances map[string]string
+	}{
+		{name: "transfer", args: []string{"transfer", "A", "B", "10"}, balances: map[string]string{"A": "90", "B": "210"}},
+		{name: "legacy invoke verb", args: []string{"invoke", "A", "B", "100"}, balances: map[string]string{"A": "0", "B": "300"}},
+		{name: "trailing zeros", args: []string{"transfer", "A", "B", "2.000"}, balances: map[string]string{"A": "98", "B": "202"}},
+		{name: "fraction beyond scale", args: []string{"transfer", "A", "B", "2.5"}, status: shim.ERRORTHRESHOLD, message: codeInvalidArgument},
+		{name: "transfer arity", args: []string{"transfer", "A", "B"}, status: shim.ERRORTHRESHOLD, message: codeBadArity},
+		{name: "non-decimal amount", args: []string{"transfer", "A", "B", "ten"}, status: shim.ERRORTHRESHOLD, message: codeInvalidAmount},
+		{name: "negative amount", args: []string{"transfer", "A", "B", "-5"}, status: shim.ERRORTHRESHOLD, message: codeNegativeAmount},
+		{name: "zero amount", args: []string{"transfer", "A", "B", "0"}, status: shim.ERRORTHRESHOLD, message: codeZeroAmount},
+		{name: "amount below scale", args: []string{"transfer", "A", "B", "0.4"}, status: shim.ERRORTHRESHOLD, message: codeInvalidArgument},
+		{name: "overdraft", args: []string{"transfer", "A", "B", "101"}, status: shim.ERRORTHRESHOLD, message: codeInsufficientFunds},
+		{name: "self transfer", args: []string{"transfer", "A", "A", "1"}, status: shim.ERRORTHRESHOLD, message: codeSelfTransfer},
+		{name: "missing payee", args: []string{"transfer", "A", "C", "1"}, status: shim.ERRORTHRESHOLD, message: codeEntityNotFound},
+		{name: "missing payer", args: []string{"transfer", "C", "A", "1"}, status: shim.ERRORTHRESHOLD, message: codeEntityNotFound},
+		{name: "transfer by non-owner", asAlice: true, args: []string{"transfer", "A", "B", "1"}, status: shim.ERRORTHRESHOLD, message: codeUnauthorized},
+		{name: "unknown function", args: []string{"pay", "A", "B", "1"}, status: shim.ERRORTHRESHOLD, message: "Expecting one of: "},
+		{name: "no function", args: []string{}, status: shim.ERRORTHRESHOLD, message: codeUnknownFunction},
+		{name: "delete", args: []string{"delete", "A"}, balances: map[string]string{"B": "200"}},
+		{name: "delete arity", args: []string{"delete"}, status: shim.ERRORTHRESHOLD, message: codeBadArity},
+		{name: "delete too many args", args: []string{"delete", "A", "B"}, status: shim.ERRORTHRESHOLD, message: codeBadArity},
+		{name: "delete missing entity", args: []string{"delete", "C"}, status: shim.ERRORTHRESHOLD, message: codeEntityNotFound},
+		{name: "delete by non-admin", asAlice: true, args: []string{"delete", "A"}, status: shim.ERRORTHRESHOLD, message: codeUnauthorized},
+		{name: "create", asAlice: true, args: []string{"create", "C"}, balances: map[string]string{"C": "0"}},
+		{name: "create funded by admin", args: []string{"create", "C", "5"}, balances: map[string]string{"C": "5"}},
+		{name: "create funded by non-admin", asAlice: true, args: []string{"create", "C", "5"}, status: shim.ERRORTHRESHOLD, message: codeUnauthorized},
+		{name: "create existing", args: []string{"create", "A"}, status: shim.ERRORTHRESHOLD, message: codeAccountExists},
+		{name: "create batch", args: []string{"createBatch", `[{"id":"C"},{"id":"D","balance":"7"}]`}, balances: map[string]string{"C": "0", "D": "7"}},
+		{name: "create batch duplicate", args: []string{"createBatch", `[{"id":"C"},{"id":"C"}]`}, status: shim.ERRORTHRESHOLD, message: codeAccountExists},
+		{name: "create batch existing", args: []string{"createBatch", `[{"id":"C"},{"id":"B"}]`}, status: shim.ERRORTHRESHOLD, message: codeAccountExists},
+		{name: "create batch empty", args: []string{"createBatch", `[]`}, status: shim.ERRORTHRESHOLD, message: "no accounts given"},
+		{name: "create batch not JSON", args: []string{"createBatch", `[{"id":`}, status: shim.ERRORTHRESHOLD, message: codeInvalidArgument},
+		{name: "query arity", args: []string{"query"}, status: shim.ERRORTHRESHOLD, message: codeBadArity},
+		{name: "query missing entity", args: []string{"query", "C"}, status: shim.ERRORTHRESHOLD, message: "Entity C not found"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			caller := f.admin
+			if test.asAlice {
+				caller = f.alice
+			}
+			res := f.invoke(caller, test.args...)
+			if test.status != 0 {
+				checkFailed(t, res, test.status, test.message)
+				f.checkBalance(t, "A", "100")
+				f.checkBalance(t, "B", "200")
+				return
+			}
+			checkOK(t, res)
+			for id, want := range test.balances {
+				f.checkBalance(t, id, want)
+			}
+		})
+	}
+}
+
+func TestCreateBatchIsAtomic(t *testing.T) {
+	f := newFixture(t)
+	checkFailed(t, f.invoke(f.admin, "createBatch", `[{"id":"C"},{"id":"D","balance":"-1"}]`), shim.ERRORTHRESHOLD, codeNegativeAmount)
+	checkFailed(t, f.invoke(f.admin, "query", "C"), shim.ERRORTHRESHOLD, "Entity C not found")
+}
+
+func TestErrorsAreJSON(t *testing.T) {
+	f := newFixture(t)
+	res := f.invoke(f.admin, "query", `C"}`)
+	checkFailed(t, res, shim.ERRORTHRESHOLD, codeEntityNotFound)
+
+	var got codedError
+	if err := json.Unmarshal([]byte(res.Message), &got); err != nil {
+		t.Fatalf("error response is not JSON: %s: %q", err, res.Message)
+	}
+	want := codedError{Code: codeEntityNotFound, Message: `Entity C"} not found`, Argument: "entity"}
+	if got != want {
+		t.Fatalf("decoded %+v, expected %+v", got, want)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "10"))
+	transferTx := fmt.Sprintf("tx%d", f.seq)
+	res := f.invoke(f.alice, "query", "A")
+	checkOK(t, res)
+
+	var doc balanceDocument
+	if err := json.Unmarshal(res.Payload, &doc); err != nil {
+		t.Fatal(err)
+	}
+	want := balanceDocument{Name: "A", Amount: doc.Amount, Asset: defaultCurrency, Owner: f.id(t, f.admin), UpdatedTx: transferTx}
+	if doc != want || doc.Amount.String() != "90" {
+		t.Fatalf("query returned %s", res.Payload)
+	}
+}
+
+func TestQueryMany(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "delete", "B"))
+	res := f.invoke(f.alice, "queryMany", `["A","C","B"]`)
+	checkOK(t, res)
+
+	var results []queryResult
+	if err := json.Unmarshal(res.Payload, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %s", res.Payload)
+	}
+	if a := results[0]; a.Name != "A" || a.Error != nil || a.Balance == nil || a.Balance.Amount.String() != "100" {
+		t.Fatalf("unexpected result for A: %s", res.Payload)
+	}
+	if c := results[1]; c.Name != "C" || c.Balance != nil || c.Error == nil || c.Error.Code != codeEntityNotFound {
+		t.Fatalf("expected C to be marked not found: %s", res.Payload)
+	}
+	if b := results[2]; b.Name != "B" || b.Balance != nil || b.Error == nil || b.Error.Code != codeAccountDeleted {
+		t.Fatalf("expected B to be marked deleted: %s", res.Payload)
+	}
+
+	checkFailed(t, f.invoke(f.alice, "queryMany", `[]`), shim.ERRORTHRESHOLD, "no entities given")
+	checkFailed(t, f.invoke(f.alice, "queryMany", `["A",""]`), shim.ERRORTHRESHOLD, codeInvalidArgument)
+	checkFailed(t, f.invoke(f.alice, "queryMany", `["A"]`, "SILVER"), shim.ERRORTHRESHOLD, codeUnknownAsset)
+}
+
+func TestList(t *testing.T) {
+	f := newFixture(t)
+	for _, id := range []string{"C", "D", "E"} {
+		checkOK(t, f.invoke(f.admin, "create", id))
+	}
+
+	var ids []string
+	bookmark := ""
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatalf("list did not end after 3 pages, got %v", ids)
+		}
+		args := []string{"list", "2"}
+		if bookmark != "" {
+			args = append(args, bookmark)
+		}
+		res := f.invoke(f.alice, args...)
+		checkOK(t, res)
+		var page accountPage
+		if err := json.Unmarshal(res.Payload, &page); err != nil {
+			t.Fatal(err)
+		}
+		if page.Count != len(page.Accounts) || page.Count > 2 {
+			t.Fatalf("unexpected page %s", res.Payload)
+		}
+		for _, acct := range page.Accounts {
+			ids = append(ids, acct.ID)
+		}
+		if bookmark = page.Bookmark; bookmark == "" {
+			break
+		}
+	}
+	if strings.Join(ids, ",") != "A,B,C,D,E" {
+		t.Fatalf("expected A to E in key order, got %v", ids)
+	}
+
+	checkFailed(t, f.invoke(f.alice, "list", strconv.Itoa(maxPageSize+1)), shim.ERRORTHRESHOLD, `"argument":"pageSize"`)
+	checkFailed(t, f.invoke(f.alice, "list", "0"), shim.ERRORTHRESHOLD, codeInvalidArgument)
+	checkOK(t, f.invoke(f.alice, "list", strconv.Itoa(maxPageSize)))
+}
+
+func TestHistory(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "10"))
+	first := fmt.Sprintf("tx%d", f.seq)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "15"))
+	second := fmt.Sprintf("tx%d", f.seq)
+
+	res := f.invoke(f.alice, "history", "A")
+	checkOK(t, res)
+	var entries []historyEntry
+	if err := json.Unmarshal(res.Payload, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %s", res.Payload)
+	}
+	for i, want := range []string{"100", "90", "75"} {
+		if entries[i].Account == nil || entries[i].Account.Balance.String() != want || entries[i].IsDelete {
+			t.Fatalf("entry %d should hold %s, got %s", i, want, res.Payload)
+		}
+	}
+	if entries[1].TxID != first || entries[2].TxID != second {
+		t.Fatalf("expected %s then %s, got %s", first, second, res.Payload)
+	}
+}
+
+func TestTransferEvent(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "10"))
+
+	f.checkEvent(t, eventTransfer)
+	var event struct {
+		Version int    `json:"version"`
+		TxID    string `json:"txid"`
+		From    string `json:"from"`
+		To      string `json:"to"`
+		Amount  string `json:"amount"`
+	}
+	if err := json.Unmarshal(f.lastEvent.Payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Version != eventSchemaVersion || event.TxID == "" || event.From != "A" || event.To != "B" || event.Amount != "10" {
+		t.Fatalf("unexpected event payload %s", f.lastEvent.Payload)
+	}
+}
+
+func TestLegacyIntegerState(t *testing.T) {
+	f := newFixture(t)
+	f.MockTransactionStart("legacy")
+	f.MockStub.PutState("L", []byte("50"))
+	f.MockTransactionEnd("legacy")
+
+	f.checkBalance(t, "L", "50")
+	// Legacy accounts have no owner, so only an admin may debit them.
+	checkFailed(t, f.invoke(f.alice, "transfer", "L", "A", "5"), shim.ERRORTHRESHOLD, codeUnauthorized)
+	checkOK(t, f.invoke(f.admin, "transfer", "L", "A", "5"))
+
+	acct, err := decodeAccount("L", f.State["L"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.Version != accountSchemaVersion || acct.Balance.String() != "45" || acct.UpdatedTx == "" {
+		t.Fatalf("legacy account was not migrated: %s", f.State["L"])
+	}
+}
+
+func TestCorruptState(t *testing.T) {
+	f := newFixture(t)
+	for _, value := range []string{"12abc", `{"version":1,"id":"X"`, `{"version":99,"id":"C","balance":"1","currency":"UNIT"}`, `{"version":3,"id":"D","balance":"1","currency":"UNIT"}`, `{"version":3,"id":"C","balance":"1","currency":"UNIT"}}`} {
+		f.MockTransactionStart("corrupt")
+		f.MockStub.PutState("C", []byte(value))
+		f.MockTransactionEnd("corrupt")
+
+		checkFailed(t, f.invoke(f.alice, "query", "C"), shim.ERROR, "Corrupt state for C")
+		checkFailed(t, f.invoke(f.admin, "transfer", "A", "C", "1"), shim.ERROR, "Corrupt state for C")
+	}
+}
+
+func TestLedgerFaults(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name    string
+		op, key string
+		args    []string
+		message string
+	}{
+		{name: "GetState on payer", op: "GetState", key: "A", args: []string{"transfer", "A", "B", "1"}, message: "Failed to get state for A"},
+		{name: "GetState on payee", op: "GetState", key: "B", args: []string{"transfer", "A", "B", "1"}, message: "Failed to get state for B"},
+		{name: "GetState on config", op: "GetState", key: "\x00config\x00chaincode\x00", args: []string{"transfer", "A", "B", "1"}, message: "Failed to get chaincode configuration"},
+		{name: "PutState on payer", op: "PutState", key: "A", args: []string{"transfer", "A", "B", "1"}, message: "boom"},
+		{name: "PutState on payee", op: "PutState", key: "B", args: []string{"transfer", "A", "B", "1"}, message: "boom"},
+		{name: "PutState on create", op: "PutState", args: []string{"create", "C"}, message: "boom"},
+		{name: "GetState on delete", op: "GetState", key: "A", args: []string{"delete", "A"}, message: "Failed to get state for A"},
+		{name: "PutState on delete", op: "PutState", key: "A", args: []string{"delete", "A"}, message: "boom"},
+		{name: "GetState on query", op: "GetState", key: "A", args: []string{"query", "A"}, message: "Failed to get state for A"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.fail(test.op, test.key, boom)
+			checkFailed(t, f.invoke(f.admin, test.args...), shim.ERROR, test.message)
+		})
+	}
+}
+
+func TestInitFaults(t *testing.T) {
+	for _, key := range []string{"A", "B", "\x00config\x00chaincode\x00"} {
+		s := newTestStub()
+		s.fail("PutState", key, errors.New("boom"))
+		checkFailed(t, s.init(newIdentity(t, "Org1MSP", "admin"), "A", "100", "B", "200"), shim.ERROR, "boom")
+	}
+}
+
+func TestFailedTransactionWritesNothing(t *testing.T) {
+	f := newFixture(t)
+	before := f.State["A"]
+	journal, _ := f.CreateCompositeKey(journalObjectType, []string{"tx2"})
+	f.fail("PutState", journal, errors.New("boom"))
+	checkFailed(t, f.invoke(f.admin, "transfer", "A", "B", "10"), shim.ERROR, "boom")
+	if string(f.State["A"]) != string(before) || len(f.history["A"]) != 1 || f.lastEvent != nil {
+		t.Fatal("failed transfer was committed")
+	}
+	f.heal()
+	f.checkBalance(t, "A", "100")
+}
+
+func TestTransactionReadsStateBeforeIt(t *testing.T) {
+	f := newFixture(t)
+	before := f.State["A"]
+	f.MockTransactionStart("t")
+	if err := f.PutState("A", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := f.GetState("A"); string(value) != string(before) {
+		t.Fatalf("transaction read its own write %s", value)
+	}
+	f.MockTransactionEnd("t")
+	f.writes = nil
+}
+
+func TestReadOnlyFunctionsCannotWrite(t *testing.T) {
+	f := newFixture(t)
+	stub := readOnlyStub{f.testStub}
+	if err := stub.PutState("A", []byte("1")); err != errReadOnly {
+		t.Fatalf("PutState through a read-only stub returned %v", err)
+	}
+	if err := stub.DelState("A"); err != errReadOnly {
+		t.Fatalf("DelState through a read-only stub returned %v", err)
+	}
+	if err := stub.SetEvent("x", nil); err != errReadOnly {
+		t.Fatalf("SetEvent through a read-only stub returned %v", err)
+	}
+	if err := stub.SetStateValidationParameter("A", []byte("ep")); err != errReadOnly {
+		t.Fatalf("SetStateValidationParameter through a read-only stub returned %v", err)
+	}
+	if err := stub.SetPrivateDataValidationParameter("c", "A", []byte("ep")); err != errReadOnly {
+		t.Fatalf("SetPrivateDataValidationParameter through a read-only stub returned %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestDeleteWritesTombstone(t *testing.T) {
+	f := newFixture(t)
+	checkOK(t, f.invoke(f.admin, "delete", "A"))
+
+	acct, err := decodeAccount("A", f.State["A"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tomb := acct.Tombstone
+	if tomb == nil || tomb.FinalBalance == nil || tomb.FinalBalance.String() != "100" || tomb.DeletedBy != f.id(t, f.admin) || tomb.DeletedTx != "tx2" {
+		t.Fatalf("unexpected tombstone %+v", tomb)
+	}
+	f.checkSupply(t, defaultCurrency, "300")
+
+	later := f.now.Add(time.Hour).Format(time.RFC3339)
+	tests := []struct {
+		name    string
+		caller  *identity
+		args    []string
+		message string
+	}{
+		{name: "transfer from", caller: f.admin, args: []string{"transfer", "A", "B", "1"}, message: codeAccountDeleted},
+		{name: "transfer to", caller: f.admin, args: []string{"transfer", "B", "A", "1"}, message: codeAccountDeleted},
+		{name: "batch payee", caller: f.admin, args: []string{"batchTransfer", "B", `[{"to":"A","amount":"1"}]`}, message: codeAccountDeleted},
+		{name: "escrow", caller: f.admin, args: []string{"escrowOpen", "e1", "B", "A", "arb", "1", later}, message: codeAccountDeleted},
+		{name: "mint", caller: f.admin, args: []string{"mint", "A", "1"}, message: codeAccountDeleted},
+		{name: "query", caller: f.alice, args: []string{"query", "A"}, message: codeAccountDeleted},
+		{name: "delete twice", caller: f.admin, args: []string{"delete", "A"}, message: codeInvalidState},
+		{name: "reuse the name", caller: f.admin, args: []string{"create", "A"}, message: codeAccountExists},
+		{name: "restore by non-admin", caller: f.alice, args: []string{"restore", "A"}, message: codeUnauthorized},
+		{name: "restore live account", caller: f.admin, args: []string{"restore", "B"}, message: codeInvalidState},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkFailed(t, f.invoke(test.caller, test.args...), shim.ERRORTHRESHOLD, test.message)
+		})
+	}
+	checkFailed(t, f.invoke(f.admin, "restore", "Z"), shim.ERRORTHRESHOLD, codeEntityNotFound)
+
+	checkOK(t, f.invoke(f.admin, "restore", "A"))
+	f.checkEvent(t, eventAccountRestored)
+	f.checkBalance(t, "A", "100")
+	checkOK(t, f.invoke(f.admin, "transfer", "A", "B", "1"))
+	f.checkBalance(t, "B", "201")
+}
+
+func TestDeletePrivateAccount(t *testing.T) {
+	f := newPrivateFixture(t)
+
+	// The admin need not be in the collection to delete or restore it.
+	record := f.PvtState[testCollection]["P"]
+	delete(f.PvtState[testCollection], "P")
+	checkOK(t, f.invoke(f.admin, "delete", "P"))
+	if tomb := f.publicDocument(t, "P")["tombstone"].(map[string]interface{}); tomb["finalBalance"] != nil {
+		t.Fatalf("tombstone reveals the balance: %v", tomb)
+	}
+	checkOK(t, f.invoke(f.admin, "restore", "P"))
+	if f.PvtState[testCollection]["P"] != nil {
+		t.Fatal("deleting wrote the private balance")
+	}
+
+	f.PvtState[testCollection]["P"] = record
+	f.checkBalance(t, "P", "50")
+	f.checkSupply(t, defaultCurrency, "300")
+}